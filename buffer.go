@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -21,27 +22,230 @@ type BufferedClientConfig struct {
 	CompressionAlgo          string        // default is gzip
 	RetryTimeIntervalInitial time.Duration // retry interval initial, default is 100ms
 	RetryTimeIntervalMax     time.Duration // retry interval max, default is 5m
+	RetryLogInterval         time.Duration // see Config.RetryLogInterval, default is 10s
 
 	MaxMessagesPerBatch int
 	MaxDurationPerBatch time.Duration
 	MaxConcurrency      int
+	RetryConcurrency    int // max concurrent attempts of failed batches, on top of MaxConcurrency, default is MaxConcurrency
+
+	AdaptiveConcurrency bool // adjust the concurrency between MinConcurrency and MaxConcurrency to the health of ingest
+	MinConcurrency      int  // lower bound of the adaptive concurrency, default is 1
+
+	// PartitionKey turns on partitioned batching: messages are spread over
+	// Partitions lanes by the hash of their key, each lane has its own batch
+	// and at most one request in flight, so messages sharing a key are
+	// delivered in the order they were sent. A lane that is slow or
+	// retrying holds its own messages, still counted by the queue limits,
+	// without slowing down the other lanes. See PartitionByField.
+	PartitionKey func(*Message) string
+	Partitions   int // number of lanes, default is MaxConcurrency
+
+	// Messages are sent with PriorityNormal unless WithPriority is passed to
+	// Send or their type is listed in TypePriorities. Each priority class is
+	// batched separately, sent first and shed last. With PartitionKey, order
+	// is only kept among messages of the same priority.
+	TypePriorities map[string]Priority
+	Priorities     map[Priority]PriorityConfig // batch limits and reserved concurrency per class
+
+	MaxQueueMessages int            // max messages waiting to be batched, default is 10000
+	MaxQueueBytes    int            // max encoded bytes waiting to be batched, default is unlimited
+	OverflowPolicy   OverflowPolicy // what to do when the queue is full, default is OverflowBlock
+
+	// QueueHighWatermark is the fraction of the queue limits at which an
+	// EventQueueWatermark is emitted and a warning logged, default is 0.8.
+	QueueHighWatermark float64
+
+	// BatchingShards runs that many independent queues and batching loops to
+	// spread the contention of many goroutines calling Send, default is 1.
+	// Messages go to a shard by PartitionKey when set, round-robin otherwise.
+	// The queue limits are split evenly among shards.
+	BatchingShards int
+
+	// MaxBufferedBytes bounds the encoded size of all messages held by the
+	// client, queued, batched or waiting for a retry. Beyond it the oldest
+	// messages of the lowest priority are dropped, default is unlimited.
+	MaxBufferedBytes int64
+
+	MaxMessageAge time.Duration            // messages not delivered by then are dropped, default is unlimited
+	TypeMaxAges   map[string]time.Duration // max ages per Message.Type, overriding MaxMessageAge
+
+	RateLimit       RateLimit            // limit on all messages, unlimited by default
+	TypeRateLimits  map[string]RateLimit // limits per Message.Type, on top of RateLimit
+	RateLimitPolicy RateLimitPolicy      // default is RateLimitDelay, TrySend always rejects
+
+	SpoolDir           string           // directory of the on-disk spool, spooling is off when empty
+	SpoolSegmentBytes  int64            // size of a spool segment file, default is 4MB
+	SpoolMaxBytes      int64            // max size of the spool, batches are sent unspooled beyond it, default is 1GB
+	SpoolFsync         SpoolFsyncPolicy // default is SpoolFsyncAlways
+	SpoolFsyncInterval time.Duration    // used with SpoolFsyncInterval, default is 1s
+
+	DeadLetterSink DeadLetterSink // receives batches that failed permanently or were cut off by Close without a spool, optional
+
+	ReturnUndelivered bool // keep the messages Close could not deliver, and neither spooled nor dead-lettered, in its UndeliveredError
+
+	Tracer Tracer // instruments batches and requests, optional
 
 	Logger Logger
 }
 
 type BufferedClient struct {
+	// accessed atomically, kept first for 64-bit alignment
+	buffered    int64 // encoded bytes held, see MaxBufferedBytes
+	expired     uint64
+	shed        uint64
+	nextBatchID int64
+	nextShard   uint32
+
 	conf   BufferedClientConfig
 	client *Client
 
-	inMsgs     chan *Message
-	outBatches chan *Messages
+	shards     []*shard
+	dispatcher *dispatcher
+	prio       [numPriorities]priorityClass
 
-	nextBatchID int64
+	limiter     *concurrencyLimiter
+	rateLimiter *rateLimiter
+	retries     *retryQueue
+	events      *eventHub
+
+	spool     *spool
+	recovered []*batch // batches left in the spool by a previous run
+
+	// ctx is cancelled when Close gives up on draining, aborting in-flight
+	// requests and their retries.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	undeliveredMu sync.Mutex
+	undelivered   UndeliveredError
 
-	closed          int64
+	pendingMu sync.Mutex
+	pending   map[*batch]struct{} // sealed batches not yet resolved
+
+	reconfigureMu sync.Mutex // serializes Reconfigure
+	pauseMu       sync.Mutex
+	resumeCh      chan struct{} // closed by Resume, nil when not paused
+
+	state           int32 // one of the client states below
 	closeCh         chan interface{}
-	batchingLoopDie chan interface{}
+	batchingLoopDie chan interface{} // closed once every shard exited
 	sendingLoopDie  chan interface{}
+	retryLoopDie    chan interface{}
+}
+
+// Lifecycle of a BufferedClient, it only ever moves forward:
+//
+//	running --Close--> draining --all goroutines exited--> closed
+//
+// Messages are only accepted while running.
+const (
+	stateRunning int32 = iota
+	stateDraining
+	stateClosed
+)
+
+// ErrClosed is returned when sending to or flushing a client that is closing
+// or closed.
+var ErrClosed = errors.New("client was closed")
+
+// envelope is a message waiting to be batched, along with the future of the
+// caller waiting for it, if any.
+type envelope struct {
+	msg    *Message
+	future *SendFuture
+	prio   Priority
+	size   int // encoded size, only computed when the queue, memory budget or rate limits count bytes
+
+	expiresAt time.Time // zero when the message never expires
+}
+
+func (e *envelope) drop(err error) {
+	if e.future != nil {
+		e.future.resolve(err)
+	}
+}
+
+// batch is a sealed batch of messages and the futures of its messages.
+type batch struct {
+	*Messages
+	futures []*SendFuture
+	expires []time.Time // expiry of each message, empty for recovered batches without max age
+	sizes   []int       // encoded size of each message, as in envelope
+	bytes   int         // sum of sizes
+	spooled *segment    // spool segment holding the batch, if any
+
+	createdAt time.Time
+	done      chan struct{} // closed once the batch is resolved
+	prio      Priority
+	lane      int // partition lane of the batch, noLane when not partitioned
+
+	data        []byte // request body, prepared on the first attempt
+	raw         int    // size of data before compression
+	attempts    int
+	sentAt      time.Time // first attempt
+	backoff     time.Duration
+	nextAttempt time.Time
+
+	// guarded by pendingMu for the debug handler, like attempts, nextAttempt
+	// and the length of Messages once the batch is tracked
+	inflight bool
+	lastErr  string
+
+	traceCtx context.Context // returned by Tracer.StartBatch
+	endTrace func(error)
+}
+
+func (b *batch) add(e *envelope) {
+	b.Messages.Messages = append(b.Messages.Messages, *e.msg)
+	b.futures = append(b.futures, e.future)
+	b.expires = append(b.expires, e.expiresAt)
+	b.sizes = append(b.sizes, e.size)
+	b.bytes += e.size
+}
+
+func (b *batch) len() int {
+	return len(b.Messages.Messages)
+}
+
+// track registers a sealed batch as pending until finish is called.
+func (bc *BufferedClient) track(b *batch) {
+	b.done = make(chan struct{})
+
+	bc.pendingMu.Lock()
+	bc.pending[b] = struct{}{}
+	bc.pendingMu.Unlock()
+}
+
+// finish resolves the futures of a batch and stops tracking it.
+func (bc *BufferedClient) finish(b *batch, err error) {
+	bc.pendingMu.Lock()
+	delete(bc.pending, b)
+	bc.pendingMu.Unlock()
+	atomic.AddInt64(&bc.buffered, -int64(b.bytes))
+
+	if b.endTrace != nil {
+		b.endTrace(err)
+	}
+	if bc.events.active() {
+		if err == nil {
+			bc.events.emit(batchEvent(EventBatchDelivered, b))
+		} else {
+			e := batchEvent(EventBatchDropped, b)
+			e.Err = err
+			bc.events.emit(e)
+		}
+	}
+	for _, f := range b.futures {
+		if f != nil {
+			f.resolve(err)
+		}
+	}
+	close(b.done)
+
+	if b.lane != noLane {
+		bc.dispatcher.settle(b.lane)
+	}
 }
 
 func NewBufferedClient(config BufferedClientConfig) (*BufferedClient, error) {
@@ -55,6 +259,8 @@ func NewBufferedClient(config BufferedClientConfig) (*BufferedClient, error) {
 		CompressionAlgo:          config.CompressionAlgo,
 		RetryTimeIntervalInitial: config.RetryTimeIntervalInitial,
 		RetryTimeIntervalMax:     config.RetryTimeIntervalMax,
+		RetryLogInterval:         config.RetryLogInterval,
+		Tracer:                   config.Tracer,
 		Logger:                   config.Logger,
 	}
 
@@ -73,135 +279,726 @@ func NewBufferedClient(config BufferedClientConfig) (*BufferedClient, error) {
 	if config.MaxConcurrency == 0 {
 		config.MaxConcurrency = 10
 	}
+	if config.MinConcurrency == 0 {
+		config.MinConcurrency = 1
+	}
+	if config.MinConcurrency > config.MaxConcurrency {
+		return nil, fmt.Errorf("MinConcurrency %d is greater than MaxConcurrency %d", config.MinConcurrency, config.MaxConcurrency)
+	}
+	if config.PartitionKey != nil && config.Partitions == 0 {
+		config.Partitions = config.MaxConcurrency
+	}
+	if config.RetryConcurrency == 0 {
+		config.RetryConcurrency = config.MaxConcurrency
+	}
+	if config.MaxQueueMessages == 0 {
+		config.MaxQueueMessages = 10000
+	}
+	if config.BatchingShards == 0 {
+		config.BatchingShards = 1
+	}
+	if config.QueueHighWatermark == 0 {
+		config.QueueHighWatermark = 0.8
+	}
+	if config.QueueHighWatermark < 0 || config.QueueHighWatermark > 1 {
+		return nil, fmt.Errorf("QueueHighWatermark %v is not within 0 and 1", config.QueueHighWatermark)
+	}
+	if config.SpoolSegmentBytes == 0 {
+		config.SpoolSegmentBytes = 4 << 20
+	}
+	if config.SpoolMaxBytes == 0 {
+		config.SpoolMaxBytes = 1 << 30
+	}
+	if config.SpoolFsyncInterval == 0 {
+		config.SpoolFsyncInterval = time.Second
+	}
+	config.Logger = client.conf.Logger
+
+	prio, err := resolvePriorities(config)
+	if err != nil {
+		return nil, err
+	}
 
 	bc := &BufferedClient{
 		conf:   config,
 		client: client,
 
-		inMsgs:     make(chan *Message),
-		outBatches: make(chan *Messages),
+		dispatcher: newDispatcher(2*config.MaxConcurrency, numPriorities*config.Partitions),
+		prio:       prio,
+		limiter:    newConcurrencyLimiter(config.AdaptiveConcurrency, config.MinConcurrency, config.MaxConcurrency),
+		retries:    newRetryQueue(),
+		events:     newEventHub(),
+
+		rateLimiter: newRateLimiter(config.RateLimit, config.TypeRateLimits, config.RateLimitPolicy),
+
+		pending: make(map[*batch]struct{}),
 
-		closed:          0,
+		state:           stateRunning,
 		closeCh:         make(chan interface{}),
 		batchingLoopDie: make(chan interface{}),
 		sendingLoopDie:  make(chan interface{}),
+		retryLoopDie:    make(chan interface{}),
+	}
+
+	bc.ctx, bc.cancel = context.WithCancel(context.Background())
+	bc.shards = make([]*shard, config.BatchingShards)
+	for i := range bc.shards {
+		bc.shards[i] = bc.newShard(i)
+	}
+
+	if config.SpoolDir != "" {
+		bc.spool, bc.recovered, err = openSpool(config, client.conf.Encoding)
+		if err != nil {
+			bc.cancel()
+			return nil, err
+		}
+		for _, b := range bc.recovered {
+			bc.track(b)
+			if config.MaxBufferedBytes > 0 {
+				for i := range b.Messages.Messages {
+					size, _ := messageSize(&b.Messages.Messages[i])
+					b.bytes += size
+					if i < len(b.sizes) {
+						b.sizes[i] = size
+					}
+				}
+				atomic.AddInt64(&bc.buffered, int64(b.bytes))
+			}
+		}
 	}
 
-	go bc.batchingLoop()
+	batchers := sync.WaitGroup{}
+	for _, s := range bc.shards {
+		batchers.Add(1)
+		go func(s *shard) {
+			defer batchers.Done()
+			bc.batchingLoop(s, batchLimits{maxMessages: config.MaxMessagesPerBatch, prio: prio})
+		}(s)
+	}
+	go func() {
+		batchers.Wait()
+		close(bc.batchingLoopDie)
+	}()
 	go bc.sendingLoop()
+	go bc.retryLoop()
 
 	return bc, nil
 }
 
-func (bc *BufferedClient) Send(ctx context.Context, message *Message) error {
-	if message == nil {
+// SendOption customizes how a single message is sent by a BufferedClient.
+type SendOption func(*sendOptions)
+
+type sendOptions struct {
+	priority    Priority
+	hasPriority bool
+	ttl         time.Duration
+	hasTTL      bool
+}
+
+// Send queues message for sending. Depending on OverflowPolicy it blocks,
+// drops messages or fails with ErrQueueFull when the queue is full. It
+// returns ErrDropped when message itself is dropped.
+func (bc *BufferedClient) Send(ctx context.Context, message *Message, opts ...SendOption) error {
+	return bc.enqueue(ctx, &envelope{msg: message}, true, opts)
+}
+
+// TrySend queues message without ever blocking. It fails with ErrQueueFull
+// when the queue is full and OverflowPolicy is OverflowBlock.
+func (bc *BufferedClient) TrySend(message *Message, opts ...SendOption) error {
+	return bc.enqueue(context.Background(), &envelope{msg: message}, false, opts)
+}
+
+// SendAsync hands message to the client and returns a future that is resolved
+// once the batch containing the message is delivered or permanently fails.
+// Failing to enqueue the message resolves the future immediately.
+func (bc *BufferedClient) SendAsync(ctx context.Context, message *Message, opts ...SendOption) *SendFuture {
+	f := newSendFuture()
+	if err := bc.enqueue(ctx, &envelope{msg: message, future: f}, true, opts); err != nil {
+		f.resolve(err)
+	}
+	return f
+}
+
+// SendAndWait sends message and blocks until it is delivered, permanently
+// fails or ctx is done.
+func (bc *BufferedClient) SendAndWait(ctx context.Context, message *Message, opts ...SendOption) error {
+	return bc.SendAsync(ctx, message, opts...).Wait(ctx)
+}
+
+func (bc *BufferedClient) enqueue(ctx context.Context, e *envelope, block bool, opts []SendOption) error {
+	if e.msg == nil {
 		return fmt.Errorf("message cannot be nil")
 	}
-	if atomic.LoadInt64(&bc.closed) == 1 {
-		return fmt.Errorf("client was closed")
+	if atomic.LoadInt32(&bc.state) != stateRunning {
+		return ErrClosed
 	}
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case bc.inMsgs <- message:
+	var o sendOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	prio, err := bc.priorityOf(e.msg, o)
+	if err != nil {
+		return err
+	}
+	e.prio = prio
+	if ttl := bc.ttlOf(e.msg, o); ttl > 0 {
+		e.expiresAt = time.Now().Add(ttl)
+	}
+
+	if bc.conf.MaxQueueBytes > 0 || bc.conf.MaxBufferedBytes > 0 || (bc.rateLimiter != nil && bc.rateLimiter.bytesLimited) {
+		size, err := messageSize(e.msg)
+		if err != nil {
+			return err
+		}
+		e.size = size
+	}
+
+	if bc.rateLimiter != nil {
+		usage := []rateUsage{{typ: e.msg.Type, messages: 1, bytes: e.size}}
+		if err := bc.rateLimiter.wait(ctx, usage, block); err != nil {
+			return err
+		}
+	}
+
+	if err := bc.reserve(e); err != nil {
+		return err
+	}
+	if err := bc.shardOf(e.msg).queue.push(ctx, e, block); err != nil {
+		if err != ErrDropped { // a dropped message already gave back its budget
+			bc.unreserve(e)
+		}
+		return err
+	}
+	return nil
+}
+
+// messageSize estimates the encoded size of m.
+func messageSize(m *Message) (int, error) {
+	b, err := fastjson.Marshal(m)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// RateLimitStats reports the usage of the configured rate limits.
+func (bc *BufferedClient) RateLimitStats() []RateLimitStat {
+	return bc.rateLimiter.stats()
+}
+
+// ConcurrencyLimit returns how many fresh batches may currently be sent at the
+// same time. It only changes when AdaptiveConcurrency is on.
+func (bc *BufferedClient) ConcurrencyLimit() int {
+	return bc.limiter.current()
+}
+
+// Flush seals the batch being filled and waits until every message sent
+// before the call is delivered or has permanently failed.
+func (bc *BufferedClient) Flush(ctx context.Context) error {
+	if atomic.LoadInt32(&bc.state) != stateRunning {
+		return ErrClosed
+	}
+
+	seals := make([]chan struct{}, len(bc.shards))
+	for i, s := range bc.shards {
+		seals[i] = make(chan struct{})
+		select {
+		case s.flushCh <- seals[i]:
+		case <-bc.closeCh:
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for _, sealed := range seals {
+		select {
+		case <-sealed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	bc.pendingMu.Lock()
+	waits := make([]chan struct{}, 0, len(bc.pending))
+	for b := range bc.pending {
+		waits = append(waits, b.done)
+	}
+	bc.pendingMu.Unlock()
+
+	for _, done := range waits {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 	return nil
 }
 
+// Close stops accepting messages and waits for the buffered ones to be
+// delivered. When ctx is done first, in-flight requests and their retries are
+// cancelled, and once every goroutine of the client has exited an
+// *UndeliveredError describing what was left behind is returned. With a spool
+// configured, undelivered batches stay on disk for the next client.
 func (bc *BufferedClient) Close(ctx context.Context) error {
 	bc.conf.Logger.Debug("calling close client")
-	if atomic.CompareAndSwapInt64(&bc.closed, 0, 1) {
+	defer bc.client.retryLog.flush()
+	if atomic.CompareAndSwapInt32(&bc.state, stateRunning, stateDraining) {
+		for _, s := range bc.shards {
+			s.queue.close()
+		}
 		close(bc.closeCh)
 	}
 
 	select {
-	case <-bc.sendingLoopDie:
-		return nil
+	case <-bc.retryLoopDie:
+		return bc.undeliveredError(nil)
 	case <-ctx.Done():
-		return ctx.Err()
 	}
+
+	bc.conf.Logger.Warn("close deadline reached, cancelling in-flight batches")
+	bc.cancel()
+	<-bc.retryLoopDie
+	return bc.undeliveredError(ctx.Err())
+}
+
+// UndeliveredError is returned by Close when some buffered messages could not
+// be delivered before its ctx was done.
+type UndeliveredError struct {
+	Batches  int // all undelivered batches, including those kept by the spool or the dead-letter sink
+	Messages int
+
+	// Undelivered holds the messages themselves when ReturnUndelivered is
+	// set, but for those kept by the spool or written to the DeadLetterSink.
+	// Each undelivered message is handed back in a single place, so resending
+	// these along with replaying the spool and the dead letters never
+	// delivers a message twice.
+	Undelivered []Message
+
+	Err error // why draining stopped, usually the ctx error
+}
+
+func (e *UndeliveredError) Error() string {
+	return fmt.Sprintf("%d messages in %d batches were not delivered: %v", e.Messages, e.Batches, e.Err)
+}
+
+func (e *UndeliveredError) Unwrap() error {
+	return e.Err
+}
+
+// addUndelivered counts b, its messages are only kept when they are not
+// already kept somewhere else.
+func (bc *BufferedClient) addUndelivered(b *batch, kept bool) {
+	bc.undeliveredMu.Lock()
+	defer bc.undeliveredMu.Unlock()
+
+	bc.undelivered.Batches++
+	bc.undelivered.Messages += b.len()
+	if bc.conf.ReturnUndelivered && !kept {
+		bc.undelivered.Undelivered = append(bc.undelivered.Undelivered, b.Messages.Messages...)
+	}
+}
+
+func (bc *BufferedClient) undeliveredError(err error) error {
+	bc.undeliveredMu.Lock()
+	defer bc.undeliveredMu.Unlock()
+
+	if bc.undelivered.Batches == 0 {
+		return err
+	}
+	if err == nil {
+		err = context.Canceled
+	}
+	uerr := bc.undelivered
+	uerr.Err = err
+	return &uerr
 }
 
-func (bc *BufferedClient) batchingLoop() {
-	defer bc.conf.Logger.Debug("batching loop exited")
-	defer close(bc.batchingLoopDie)
-	timer := time.NewTimer(bc.conf.MaxDurationPerBatch)
+func (bc *BufferedClient) batchingLoop(s *shard, limits batchLimits) {
+	defer bc.conf.Logger.WithField("shard", s.id).Debug("batching loop exited")
+
+	partitions := 1
+	if bc.conf.PartitionKey != nil {
+		partitions = bc.conf.Partitions
+	}
+
+	newBatch := func(i int) *batch {
+		batchID := fmt.Sprintf("b-%d-%d", time.Now().UnixMilli(), atomic.AddInt64(&bc.nextBatchID, 1)-1)
+
+		b := &batch{Messages: &Messages{BatchId: batchID}, createdAt: time.Now(), prio: Priority(i/partitions) + PriorityLow, lane: noLane}
+		if bc.conf.PartitionKey != nil {
+			b.lane = i
+		}
+		return b
+	}
+
+	// limits are owned by this loop, Reconfigure hands it new ones through
+	// the limitsCh of the shard
+
+	// one batch being filled per priority and lane, open[p*partitions+lane]
+	open := make([]*batch, numPriorities*partitions)
+	for i := range open {
+		open[i] = newBatch(i)
+	}
+
+	// each priority class is sealed on its own schedule
+	var deadlines [numPriorities]time.Time
+	now := time.Now()
+	for p := range deadlines {
+		deadlines[p] = now.Add(limits.prio[p].maxDuration)
+	}
+	nextDeadline := func() time.Duration {
+		next := deadlines[0]
+		for _, d := range deadlines[1:] {
+			if d.Before(next) {
+				next = d
+			}
+		}
+		return time.Until(next)
+	}
+	timer := time.NewTimer(nextDeadline())
 	defer timer.Stop()
 
-	newBatch := func() *Messages {
-		batchID := fmt.Sprintf("b-%d-%d", time.Now().UnixMilli(), bc.nextBatchID)
-		bc.nextBatchID++
+	seal := func(i int, reason SealReason) {
+		b := open[i]
+		if logEnabled(bc.conf.Logger, LevelDebug) {
+			bc.conf.Logger.WithField("batchId", b.BatchId).WithField("priority", b.prio.String()).Debug("seal batch for sending (" + reason.String() + ")")
+		}
+		if bc.events.active() {
+			e := batchEvent(EventBatchSealed, b)
+			e.Reason = reason
+			bc.events.emit(e)
+		}
+		if bc.spool != nil {
+			if err := bc.spool.write(b); err != nil {
+				bc.conf.Logger.WithField("batchId", b.BatchId).WithField("err", err.Error()).Warn("unable to spool batch, sending it anyway")
+			}
+		}
+		bc.track(b)
+		bc.dispatcher.push(b)
+		open[i] = newBatch(i)
+	}
+
+	// the messages of a lane whose backlog is full wait in held, still
+	// counted by the queue, until the open batch of the lane is sealed
+	held := make([][]*envelope, len(open))
+	laneFull := func(i int) bool {
+		return bc.conf.PartitionKey != nil && bc.dispatcher.laneFull(i)
+	}
+
+	// unhold moves the messages held for open batch i into it, sealing it
+	// whenever it is full and its lane has room, or regardless with force.
+	unhold := func(i int, force bool) {
+		maxMessages := limits.prio[i/partitions].maxMessages
+		n, size := 0, 0
+		now := time.Now()
+		for {
+			if open[i].len() >= maxMessages {
+				if !force && laneFull(i) {
+					break
+				}
+				seal(i, SealSize)
+			}
+			if len(held[i]) == 0 {
+				break
+			}
+			e := held[i][0]
+			held[i][0] = nil
+			held[i] = held[i][1:]
+			n++
+			size += e.size
+			if e.expired(now) {
+				bc.expire(e)
+				continue
+			}
+			open[i].add(e)
+		}
+		s.queue.release(n, size)
+	}
 
-		return &Messages{BatchId: batchID}
+	// sealPriority seals the open batches of a class, but for the ones of
+	// full lanes unless force is set.
+	sealPriority := func(p int, reason SealReason, force bool) {
+		for i := p * partitions; i < (p+1)*partitions; i++ {
+			unhold(i, force)
+			if open[i].len() > 0 && (force || !laneFull(i)) {
+				seal(i, reason)
+			}
+		}
+	}
+	sealAll := func(reason SealReason) {
+		for p := numPriorities - 1; p >= 0; p-- {
+			sealPriority(p, reason, true)
+		}
 	}
 
-	b := newBatch()
+	// fill moves queued messages into batches, returns once no message is
+	// left of the classes with room in the dispatcher, or of any class with
+	// force.
+	fill := func(force bool) {
+		for {
+			classes := allPriorities
+			if !force {
+				classes = bc.dispatcher.room()
+			}
+			es := s.queue.take(limits.maxMessages, classes)
+			if len(es) == 0 {
+				return
+			}
+			now := time.Now()
+			n, size := 0, 0
+			for _, e := range es {
+				if e.expired(now) {
+					bc.expire(e)
+					n++
+					size += e.size
+					continue
+				}
+
+				p := e.prio.index()
+				i := p*partitions + bc.laneOf(s, e.msg, partitions)
+				if len(held[i]) > 0 || open[i].len() >= limits.prio[p].maxMessages {
+					held[i] = append(held[i], e)
+					continue
+				}
+				open[i].add(e)
+				n++
+				size += e.size
+
+				if open[i].len() >= limits.prio[p].maxMessages && !laneFull(i) {
+					seal(i, SealSize)
+					if partitions == 1 {
+						deadlines[p] = time.Now().Add(limits.prio[p].maxDuration)
+					}
+				}
+			}
+			s.queue.release(n, size)
+		}
+	}
 
 	for {
+		// hold off taking the messages of the classes without room in the
+		// dispatcher, leaving them to the queue and its overflow policy
+		space := bc.dispatcher.space()
+		var ready <-chan struct{}
+		if bc.dispatcher.room() != ([numPriorities]bool{}) {
+			ready = s.queue.ready()
+		}
+
 		select {
-		case msg := <-bc.inMsgs:
-			b.Messages = append(b.Messages, *msg)
-
-			if len(b.Messages) >= bc.conf.MaxMessagesPerBatch {
-				bc.conf.Logger.WithField("batchId", b.BatchId).Debug("seal batch for sending (number of message reach limit)")
-				bc.outBatches <- b
-				b = newBatch()
-				timer.Reset(bc.conf.MaxDurationPerBatch)
+		case <-ready:
+			fill(false)
+		case <-space:
+			for i := range open {
+				if len(held[i]) > 0 || open[i].len() >= limits.prio[i/partitions].maxMessages {
+					unhold(i, false)
+				}
 			}
+			fill(false)
 		case <-timer.C:
-			if len(b.Messages) > 0 {
-				bc.conf.Logger.WithField("batchId", b.BatchId).Debug("seal batch for sending (batch live duration reach limit)")
-				bc.outBatches <- b
-				b = newBatch()
+			now := time.Now()
+			for p := numPriorities - 1; p >= 0; p-- {
+				if !now.Before(deadlines[p]) {
+					sealPriority(p, SealTime, false)
+					deadlines[p] = now.Add(limits.prio[p].maxDuration)
+				}
 			}
-		case <-bc.closeCh:
-			if len(b.Messages) > 0 {
-				bc.conf.Logger.WithField("batchId", b.BatchId).Debug("seal batch for sending (client is closing)")
-				bc.outBatches <- b
+			timer.Reset(nextDeadline())
+		case limits = <-s.limitsCh:
+			now := time.Now()
+			for p := range deadlines {
+				if d := now.Add(limits.prio[p].maxDuration); d.Before(deadlines[p]) {
+					deadlines[p] = d
+				}
+				for i := p * partitions; i < (p+1)*partitions; i++ {
+					if open[i].len() >= limits.prio[p].maxMessages && !laneFull(i) {
+						seal(i, SealReconfig)
+					}
+				}
 			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(nextDeadline())
+		case sealed := <-s.flushCh:
+			fill(true)
+			sealAll(SealFlush)
+			close(sealed)
+		case <-bc.closeCh:
+			fill(true)
+			sealAll(SealClose)
 			return
 		}
 	}
 }
 
+// sendingLoop makes the first attempt of every sealed batch with at most
+// MaxConcurrency requests in flight, or the adaptive limit when
+// AdaptiveConcurrency is on. Higher priorities go first and may keep slots
+// reserved from lower ones. Batches that fail with a retryable error are
+// handed to the retry loop.
 func (bc *BufferedClient) sendingLoop() {
 	defer bc.conf.Logger.Debug("sending loop exited")
 	defer close(bc.sendingLoopDie)
-	sema := make(chan interface{}, bc.conf.MaxConcurrency)
 	wg := sync.WaitGroup{}
 
+	for _, b := range bc.recovered {
+		bc.dispatcher.push(b)
+	}
+	bc.recovered = nil
+
+	acquire := func(p Priority) bool {
+		return bc.limiter.tryAcquire(bc.prio[p.index()].reservedAbove)
+	}
+	batchingLoopDie := bc.batchingLoopDie
+
 	for {
-		select {
-		case b := <-bc.outBatches:
-			sema <- nil
-			wg.Add(1)
+		if resumed := bc.resumed(); resumed != nil {
+			select {
+			case <-resumed:
+			case <-bc.ctx.Done():
+			}
+			continue
+		}
 
+		released := bc.limiter.released()
+		if b := bc.dispatcher.next(acquire); b != nil {
+			wg.Add(1)
 			go func() {
-				defer func() { <-sema; wg.Done() }()
+				defer func() { bc.limiter.release(); wg.Done() }()
 				bc.sendBatch(b)
 			}()
-		case <-bc.batchingLoopDie:
+			continue
+		}
+
+		if batchingLoopDie == nil && bc.dispatcher.idle() {
 			wg.Wait()
 			return
 		}
+
+		select {
+		case <-bc.dispatcher.wake():
+		case <-released:
+		case <-batchingLoopDie:
+			batchingLoopDie = nil
+		}
+	}
+}
+
+func (bc *BufferedClient) sendBatch(b *batch) {
+	if bc.prune(b) {
+		return
+	}
+
+	b.sentAt = time.Now()
+
+	if logEnabled(bc.conf.Logger, LevelDebug) {
+		bc.conf.Logger.WithField("batchId", b.BatchId).WithField("messages", b.len()).Debug("sending batch")
 	}
+	bc.attempt(b)
 }
 
-func (bc *BufferedClient) sendBatch(b *Messages) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// attempt makes a single request for b, then settles the batch or schedules
+// its next attempt. Messages past their max age are dropped first.
+func (bc *BufferedClient) attempt(b *batch) {
+	if bc.prune(b) {
+		return
+	}
+	if b.data == nil {
+		data, raw, err := bc.client.prepare(b.Messages)
+		if err != nil {
+			bc.fail(b, err)
+			return
+		}
+		b.data, b.raw = data, raw
+	}
+	info := BatchInfo{BatchId: b.BatchId, Messages: b.len(), EncodedBytes: b.raw, CompressedBytes: len(b.data)}
+	if bc.conf.Tracer != nil && b.traceCtx == nil {
+		b.traceCtx, b.endTrace = bc.conf.Tracer.StartBatch(bc.ctx, info)
+	}
+	ctx := bc.ctx
+	if b.traceCtx != nil {
+		ctx = b.traceCtx
+	}
 
+	bc.pendingMu.Lock()
+	b.attempts++
+	b.inflight = true
+	bc.pendingMu.Unlock()
+	if b.attempts > 1 {
+		bc.client.metrics.retried()
+	}
 	start := time.Now()
-
-	bc.conf.Logger.WithField("batchId", b.BatchId).WithField("messages", len(b.Messages)).Debug("sending batch")
-	err := bc.client.Collect(ctx, b)
+	err := bc.client.send(ctx, b.data, AttemptInfo{BatchInfo: info, Attempt: b.attempts})
+	latency := time.Since(start)
+	bc.pendingMu.Lock()
+	b.inflight = false
 	if err != nil {
-		bc.conf.Logger.WithField("batchId", b.BatchId).WithField("error", err.Error()).Error("failed to send batch")
-		return
+		b.lastErr = err.Error()
+	}
+	bc.pendingMu.Unlock()
+	if bc.ctx.Err() == nil {
+		bc.limiter.observe(latency, err)
+	}
+	if bc.events.active() {
+		e := batchEvent(EventAttempt, b)
+		e.Attempt, e.StatusCode, e.Latency, e.Err = b.attempts, statusCode(err), latency, err
+		bc.events.emit(e)
+	}
+
+	switch {
+	case err == nil:
+		if b.spooled != nil {
+			bc.spool.ack(b.spooled)
+		}
+		bc.client.metrics.delivered(b.len())
+		bc.finish(b, nil)
+		if logEnabled(bc.conf.Logger, LevelDebug) {
+			bc.conf.Logger.WithField("batchId", b.BatchId).WithField("elapsed", time.Since(b.sentAt).String()).Debug("batch successfully sent")
+		}
+	case bc.ctx.Err() != nil:
+		bc.giveUp(b, err)
+	case shouldRetry(err):
+		bc.scheduleRetry(b, err)
+	default:
+		bc.fail(b, err)
 	}
+}
 
-	elapsed := time.Since(start)
+// fail settles a batch that failed permanently.
+func (bc *BufferedClient) fail(b *batch, err error) {
+	bc.conf.Logger.WithField("batchId", b.BatchId).WithField("error", err.Error()).Error("failed to send batch")
+	bc.deadLetter(b, err)
+	if b.spooled != nil {
+		bc.spool.ack(b.spooled)
+	}
+	bc.client.metrics.failed(b.len())
+	bc.finish(b, err)
+}
 
-	bc.conf.Logger.WithField("batchId", b.BatchId).WithField("elapsed", elapsed.String()).Debug("batch successfully sent")
+// giveUp settles a batch interrupted by Close. A spooled batch is kept for
+// the next run, any other one goes to the dead-letter sink, or else to the
+// UndeliveredError.
+func (bc *BufferedClient) giveUp(b *batch, err error) {
+	bc.conf.Logger.WithField("batchId", b.BatchId).Warn("batch not delivered before client closed")
+	kept := b.spooled != nil || bc.deadLetter(b, err)
+	bc.addUndelivered(b, kept)
+	bc.finish(b, err)
+}
+
+// deadLetter writes b to the dead-letter sink, it tells whether it was.
+func (bc *BufferedClient) deadLetter(b *batch, err error) bool {
+	if bc.conf.DeadLetterSink == nil {
+		return false
+	}
+	if werr := bc.conf.DeadLetterSink.WriteDeadLetter(newDeadLetter(b, err)); werr != nil {
+		bc.conf.Logger.WithField("batchId", b.BatchId).WithField("error", werr.Error()).Error("unable to write dead letter")
+		return false
+	}
+	return true
 }
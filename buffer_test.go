@@ -0,0 +1,299 @@
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIngest is an in-process ingest server recording received batches.
+type fakeIngest struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	batches []Messages
+	handler func(w http.ResponseWriter, b *Messages) // optional, replies 200 when nil
+}
+
+func newFakeIngest(t *testing.T) *fakeIngest {
+	f := &fakeIngest{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+
+		var b Messages
+		if err := json.NewDecoder(body).Decode(&b); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		f.mu.Lock()
+		handler := f.handler
+		f.mu.Unlock()
+		if handler != nil {
+			handler(w, &b)
+			return
+		}
+
+		f.record(&b)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeIngest) setHandler(h func(w http.ResponseWriter, b *Messages)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handler = h
+}
+
+func (f *fakeIngest) record(b *Messages) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, *b)
+}
+
+func (f *fakeIngest) received() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, b := range f.batches {
+		n += len(b.Messages)
+	}
+	return n
+}
+
+func testBufferedConfig(endpoint string) BufferedClientConfig {
+	return BufferedClientConfig{
+		Endpoint: endpoint,
+
+		AccessKeyID:     "rQJEk4mz6k",
+		AccessKeySecret: "CkYyCc==",
+
+		RetryTimeIntervalInitial: 5 * time.Millisecond,
+		RetryTimeIntervalMax:     20 * time.Millisecond,
+
+		MaxDurationPerBatch: 5 * time.Millisecond,
+
+		Logger: NewLogger(io.Discard, LevelError),
+	}
+}
+
+func newTestMessage(i int) *Message {
+	return &Message{Type: "Event", Data: map[string]interface{}{"#event": "login", "i": i}}
+}
+
+func TestSendAndWait(t *testing.T) {
+	srv := newFakeIngest(t)
+
+	bc, err := NewBufferedClient(testBufferedConfig(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		if err := bc.SendAndWait(ctx, newTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := srv.received(); n != 3 {
+		t.Fatalf("expected 3 messages delivered, got %d", n)
+	}
+}
+
+func TestSendAsyncPermanentFailure(t *testing.T) {
+	srv := newFakeIngest(t)
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "bad schema"}`))
+	})
+
+	bc, err := NewBufferedClient(testBufferedConfig(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	f := bc.SendAsync(ctx, newTestMessage(0))
+	select {
+	case <-f.Done():
+	case <-ctx.Done():
+		t.Fatal("future was not resolved")
+	}
+
+	var ierr Error
+	if !errors.As(f.Err(), &ierr) || ierr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected error %v", f.Err())
+	}
+}
+
+func TestCloseDeadlineReportsUndelivered(t *testing.T) {
+	srv := newFakeIngest(t)
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	conf := testBufferedConfig(srv.URL)
+	conf.MaxMessagesPerBatch = 2
+	conf.ReturnUndelivered = true
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var futures []*SendFuture
+	for i := 0; i < 5; i++ {
+		futures = append(futures, bc.SendAsync(context.Background(), newTestMessage(i)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = bc.Close(ctx)
+
+	var uerr *UndeliveredError
+	if !errors.As(err, &uerr) {
+		t.Fatalf("expected UndeliveredError, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected error to wrap the ctx error, got %v", err)
+	}
+	if uerr.Messages != 5 || uerr.Batches < 3 || len(uerr.Undelivered) != 5 {
+		t.Fatalf("unexpected undelivered report %+v", uerr)
+	}
+
+	select {
+	case <-bc.sendingLoopDie:
+	default:
+		t.Fatal("sending loop still running after Close returned")
+	}
+	for _, f := range futures {
+		select {
+		case <-f.Done():
+		default:
+			t.Fatal("future left unresolved after Close returned")
+		}
+	}
+}
+
+func TestSendAfterClose(t *testing.T) {
+	srv := newFakeIngest(t)
+
+	bc, err := NewBufferedClient(testBufferedConfig(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bc.Send(context.Background(), newTestMessage(0)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from Send, got %v", err)
+	}
+	if err := bc.TrySend(newTestMessage(0)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from TrySend, got %v", err)
+	}
+	if err := bc.SendAsync(context.Background(), newTestMessage(0)).Err(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from SendAsync, got %v", err)
+	}
+	if err := bc.Flush(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from Flush, got %v", err)
+	}
+}
+
+// TestConcurrentSendFlushClose is mostly useful with -race: every Send must
+// return promptly, and every accepted message must be delivered.
+func TestConcurrentSendFlushClose(t *testing.T) {
+	srv := newFakeIngest(t)
+
+	conf := testBufferedConfig(srv.URL)
+	conf.MaxMessagesPerBatch = 7
+	conf.MaxQueueMessages = 16
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted int
+	)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				err := bc.Send(context.Background(), newTestMessage(i))
+				if errors.Is(err, ErrClosed) {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			if err := bc.Flush(context.Background()); errors.Is(err, ErrClosed) {
+				return
+			} else if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	closeErrs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { closeErrs <- bc.Close(ctx) }()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-closeErrs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("senders hang after Close")
+	}
+
+	if n := srv.received(); n != accepted {
+		t.Fatalf("accepted %d messages but delivered %d", accepted, n)
+	}
+}
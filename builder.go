@@ -0,0 +1,303 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message types and reserved fields of the messages made by the builders.
+const (
+	TypeEvent         = "Event"
+	TypeUserSet       = "UserSet"
+	TypeUserSetOnce   = "UserSetOnce"
+	TypeUserIncrement = "UserIncrement"
+	TypeUserAppend    = "UserAppend"
+	TypeUserUnset     = "UserUnset"
+
+	FieldEvent  = "#event"
+	FieldTime   = "#time" // unix milliseconds
+	FieldUserID = "#user_id"
+)
+
+// ErrInvalidMessage is wrapped by the errors of the builders.
+var ErrInvalidMessage = errors.New("invalid message")
+
+func invalidf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidMessage, fmt.Sprintf(format, args...))
+}
+
+// EventBuilder builds an Event message:
+//
+//	msg, err := client.NewEvent("login").User(42).Prop("ip", "127.0.0.1").Build()
+type EventBuilder struct {
+	name   string
+	at     time.Time
+	userID interface{}
+	props  map[string]interface{}
+	err    error
+}
+
+// NewEvent starts an event named name, happening now unless At is called.
+func NewEvent(name string) *EventBuilder {
+	return &EventBuilder{name: name, props: make(map[string]interface{})}
+}
+
+// At sets when the event happened.
+func (b *EventBuilder) At(t time.Time) *EventBuilder {
+	b.at = t
+	return b
+}
+
+// User sets the user the event is about, a string or an integer.
+func (b *EventBuilder) User(id interface{}) *EventBuilder {
+	b.userID = id
+	return b
+}
+
+// Prop sets a property of the event. Names starting with # are reserved.
+func (b *EventBuilder) Prop(name string, value interface{}) *EventBuilder {
+	if b.err == nil {
+		b.err = checkProp(name, value)
+	}
+	b.props[name] = value
+	return b
+}
+
+// Props sets several properties of the event, see Prop. They are checked in
+// the order of their names, so that the error reported is always the same.
+func (b *EventBuilder) Props(props map[string]interface{}) *EventBuilder {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.Prop(name, props[name])
+	}
+	return b
+}
+
+// Build returns the message, or the first invalid field set.
+func (b *EventBuilder) Build() (*Message, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.name == "" {
+		return nil, invalidf("event name is required")
+	}
+	if strings.HasPrefix(b.name, "#") {
+		return nil, invalidf("event name %q starts with #", b.name)
+	}
+
+	data := make(map[string]interface{}, len(b.props)+3)
+	for name, value := range b.props {
+		data[name] = value
+	}
+	data[FieldEvent] = b.name
+	data[FieldTime] = unixMilli(b.at)
+	if b.userID != nil {
+		if err := checkUserID(b.userID); err != nil {
+			return nil, err
+		}
+		data[FieldUserID] = b.userID
+	}
+	return &Message{Type: TypeEvent, Data: data}, nil
+}
+
+// UserBuilder builds the messages updating the profile of a user, one per
+// kind of operation:
+//
+//	msgs, err := client.NewUser(42).Set("level", 10).Increment("coins", 100).Build()
+type UserBuilder struct {
+	userID interface{}
+	at     time.Time
+	ops    [5]map[string]interface{} // by index of userOpTypes
+	err    error
+}
+
+// userOpTypes are the message types of the operations of UserBuilder, in
+// the order their messages are built.
+var userOpTypes = [5]string{TypeUserSet, TypeUserSetOnce, TypeUserIncrement, TypeUserAppend, TypeUserUnset}
+
+const (
+	opSet = iota
+	opSetOnce
+	opIncrement
+	opAppend
+	opUnset
+)
+
+// NewUser starts updating the profile of the user id, a string or an
+// integer.
+func NewUser(id interface{}) *UserBuilder {
+	return &UserBuilder{userID: id}
+}
+
+// At sets when the update happened, default is now.
+func (b *UserBuilder) At(t time.Time) *UserBuilder {
+	b.at = t
+	return b
+}
+
+// Set sets a property of the user.
+func (b *UserBuilder) Set(name string, value interface{}) *UserBuilder {
+	return b.add(opSet, name, value, checkProp(name, value))
+}
+
+// SetOnce sets a property of the user unless it is already set.
+func (b *UserBuilder) SetOnce(name string, value interface{}) *UserBuilder {
+	return b.add(opSetOnce, name, value, checkProp(name, value))
+}
+
+// Increment adds delta, a number, to a numeric property of the user.
+func (b *UserBuilder) Increment(name string, delta interface{}) *UserBuilder {
+	err := checkProp(name, delta)
+	if err == nil && !isNumber(delta) {
+		err = invalidf("increment of %q is not a number", name)
+	}
+	return b.add(opIncrement, name, delta, err)
+}
+
+// Append appends values to a list property of the user.
+func (b *UserBuilder) Append(name string, values ...interface{}) *UserBuilder {
+	err := checkProp(name, values)
+	return b.add(opAppend, name, values, err)
+}
+
+// Unset removes a property of the user.
+func (b *UserBuilder) Unset(name string) *UserBuilder {
+	return b.add(opUnset, name, nil, checkProp(name, nil))
+}
+
+func (b *UserBuilder) add(op int, name string, value interface{}, err error) *UserBuilder {
+	if b.err == nil {
+		b.err = err
+	}
+	if b.ops[op] == nil {
+		b.ops[op] = make(map[string]interface{})
+	}
+	b.ops[op][name] = value
+	return b
+}
+
+// Build returns a message per kind of operation, in the order set, set once,
+// increment, append and unset, or the first invalid field set.
+func (b *UserBuilder) Build() ([]*Message, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.userID == nil {
+		return nil, invalidf("user id is required")
+	}
+	if err := checkUserID(b.userID); err != nil {
+		return nil, err
+	}
+
+	at := unixMilli(b.at)
+	var msgs []*Message
+	for op, props := range b.ops {
+		if props == nil {
+			continue
+		}
+		data := make(map[string]interface{}, len(props)+2)
+		for name, value := range props {
+			data[name] = value
+		}
+		data[FieldUserID] = b.userID
+		data[FieldTime] = at
+		msgs = append(msgs, &Message{Type: userOpTypes[op], Data: data})
+	}
+	if len(msgs) == 0 {
+		return nil, invalidf("no operation on user %v", b.userID)
+	}
+	return msgs, nil
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func checkUserID(id interface{}) error {
+	switch id := id.(type) {
+	case string:
+		if id == "" {
+			return invalidf("user id is empty")
+		}
+		return nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return nil
+	}
+	return invalidf("user id of type %T is neither a string nor an integer", id)
+}
+
+// checkProp validates a property name and value: names starting with # are
+// reserved, values are encoded by both JSON and msgpack.
+func checkProp(name string, value interface{}) error {
+	if name == "" {
+		return invalidf("property name is empty")
+	}
+	if strings.HasPrefix(name, "#") {
+		return invalidf("property name %q is reserved", name)
+	}
+	if err := checkValue(reflect.ValueOf(value)); err != nil {
+		return invalidf("property %q: %v", name, err)
+	}
+	return nil
+}
+
+func checkValue(v reflect.Value) error {
+	if !v.IsValid() {
+		return nil
+	}
+	if _, ok := v.Interface().(time.Time); ok {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return checkValue(v.Elem())
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := checkValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("map keys of type %s are not strings", v.Type().Key())
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := checkValue(iter.Value()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		return nil
+	}
+	return fmt.Errorf("values of type %s cannot be encoded", v.Type())
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return true
+	}
+	return false
+}
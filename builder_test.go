@@ -0,0 +1,63 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEventBuilder(t *testing.T) {
+	at := time.UnixMilli(1728904200000)
+	msg, err := NewEvent("login").At(at).User(42).Prop("ip", "127.0.0.1").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := msg.Data.(map[string]interface{})
+	if msg.Type != TypeEvent || data[FieldEvent] != "login" || data[FieldTime] != int64(1728904200000) || data[FieldUserID] != 42 || data["ip"] != "127.0.0.1" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+
+	for _, b := range []*EventBuilder{
+		NewEvent(""),
+		NewEvent("login").Prop("#time", 1),
+		NewEvent("login").Prop("cb", func() {}),
+		NewEvent("login").User(1.5),
+	} {
+		if _, err := b.Build(); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("expected ErrInvalidMessage, got %v", err)
+		}
+	}
+}
+
+func TestUserBuilder(t *testing.T) {
+	msgs, err := NewUser("u1").Unset("vip").Set("level", 10).Increment("coins", 100).Append("items", "sword").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []string{TypeUserSet, TypeUserIncrement, TypeUserAppend, TypeUserUnset}
+	if len(msgs) != len(types) {
+		t.Fatalf("expected %d messages, got %d", len(types), len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.Type != types[i] || msg.Data.(map[string]interface{})[FieldUserID] != "u1" {
+			t.Fatalf("unexpected message %d: %+v", i, msg)
+		}
+	}
+
+	if _, err := NewUser("u1").Increment("coins", "100").Build(); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("expected ErrInvalidMessage, got %v", err)
+	}
+	if _, err := NewUser("").Set("level", 1).Build(); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("expected ErrInvalidMessage, got %v", err)
+	}
+}
+
+func TestEventBuilderPropsOrder(t *testing.T) {
+	props := map[string]interface{}{"#d": 1, "#b": 2, "#c": 3, "#a": 4, "#e": 5}
+	for i := 0; i < 20; i++ {
+		_, err := NewEvent("login").Props(props).Build()
+		if err == nil || err.Error() != `invalid message: property name "#a" is reserved` {
+			t.Fatalf("expected the error of #a, got %v", err)
+		}
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"net/http"
 	"path"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -58,6 +59,17 @@ type Config struct {
 	RetryTimeIntervalInitial time.Duration // retry interval initial, default is 100ms
 	RetryTimeIntervalMax     time.Duration // retry interval max, default is 5m
 
+	// RetryLogInterval collapses the warnings of failed attempts: only the
+	// first one of an interval is logged, the others are summarized at its
+	// end. Default is 10s, negative logs every failed attempt.
+	RetryLogInterval time.Duration
+
+	RateLimit       RateLimit            // limit on all messages, unlimited by default
+	TypeRateLimits  map[string]RateLimit // limits per Message.Type, on top of RateLimit
+	RateLimitPolicy RateLimitPolicy      // default is RateLimitDelay
+
+	Tracer Tracer // instruments batches and requests, optional
+
 	Logger Logger
 }
 
@@ -65,6 +77,11 @@ type Client struct {
 	conf       Config
 	httpClient *http.Client
 	reqCount   int64
+	limiter    *rateLimiter
+	metrics    *metrics
+	retryLog   *retryLog
+
+	mu sync.RWMutex // guards Endpoint and credentials of conf, see SetEndpoint
 }
 
 var (
@@ -84,6 +101,9 @@ func NewClient(config Config) (*Client, error) {
 	if config.RetryTimeIntervalMax == 0 {
 		config.RetryTimeIntervalMax = 5 * time.Second
 	}
+	if config.RetryLogInterval == 0 {
+		config.RetryLogInterval = 10 * time.Second
+	}
 
 	switch config.Encoding {
 	case "json", "msgpack":
@@ -101,56 +121,67 @@ func NewClient(config Config) (*Client, error) {
 		return nil, fmt.Errorf("unkonwn compressionAlgo %s", config.CompressionAlgo)
 	}
 
-	return &Client{conf: config, httpClient: &http.Client{}}, nil
+	return &Client{
+		conf:       config,
+		httpClient: &http.Client{},
+		limiter:    newRateLimiter(config.RateLimit, config.TypeRateLimits, config.RateLimitPolicy),
+		metrics:    newMetrics(),
+		retryLog:   newRetryLog(config.Logger, config.RetryLogInterval),
+	}, nil
 }
 
 func (c *Client) Collect(ctx context.Context, messages *Messages) error {
-	method := "POST"
-	api := "/v1/collect"
-	timeInterval := c.conf.RetryTimeIntervalInitial
-	timeIntervalMax := c.conf.RetryTimeIntervalMax
-
-	// 序列化 && 压缩数据
-	data, err := encoding(c.conf.Encoding, &messages)
-	if err != nil {
+	if err := c.collect(ctx, messages); err != nil {
+		c.metrics.failed(len(messages.Messages))
 		return err
 	}
+	c.metrics.delivered(len(messages.Messages))
+	return nil
+}
 
-	if !c.conf.NoCompression {
-		data, err = c.compress(data)
+func (c *Client) collect(ctx context.Context, messages *Messages) (err error) {
+	timeInterval := c.conf.RetryTimeIntervalInitial
+	timeIntervalMax := c.conf.RetryTimeIntervalMax
+
+	if c.limiter != nil {
+		usage, err := batchUsage(messages.Messages, c.limiter.bytesLimited)
 		if err != nil {
 			return err
 		}
+		if err := c.limiter.wait(ctx, usage, true); err != nil {
+			return err
+		}
 	}
 
-retry:
-	req, err := http.NewRequest(method, c.conf.Endpoint+api, bytes.NewBuffer(data))
+	data, raw, err := c.prepare(messages)
 	if err != nil {
 		return err
 	}
-	// Workaround 每隔20个请求清理一次连接，这样能够让每一个 ingest server 收到的请求相对均匀一点
-	if atomic.AddInt64(&c.reqCount, 1)%20 == 0 {
-		req.Close = true
-		c.httpClient.CloseIdleConnections()
-	}
-
-	req.Header.Set("Content-Type", path.Join("application", c.conf.Encoding))
-	req.Header.Set("X-Ingest-Client-ID", c.conf.ClientId)
 
-	if !c.conf.NoCompression {
-		req.Header.Set("Content-Encoding", "gzip")
+	attempt := AttemptInfo{BatchInfo: BatchInfo{
+		BatchId:         messages.BatchId,
+		Messages:        len(messages.Messages),
+		EncodedBytes:    raw,
+		CompressedBytes: len(data),
+	}}
+	if c.conf.Tracer != nil {
+		var end func(error)
+		ctx, end = c.conf.Tracer.StartBatch(ctx, attempt.BatchInfo)
+		defer func() { end(err) }()
 	}
 
-	req = req.WithContext(ctx)
-	if err := c.doRequestWithContext(req, method, api, data); err != nil {
+retry:
+	attempt.Attempt++
+	if err := c.send(ctx, data, attempt); err != nil {
 		if shouldRetry(err) {
 			timeInterval = timeInterval * 2
 			if timeInterval >= timeIntervalMax {
 				timeInterval = timeIntervalMax
 			}
-			c.conf.Logger.WithField("err", err.Error()).WithField("wait", timeInterval).WithField("batchId", messages.BatchId).Warn("failed to send request, retry later")
+			c.retryLog.failed(messages.BatchId, timeInterval, err)
 			select {
 			case <-time.After(timeInterval):
+				c.metrics.retried()
 				goto retry
 			case <-ctx.Done():
 				return ctx.Err()
@@ -163,6 +194,133 @@ retry:
 	return nil
 }
 
+// SetEndpoint changes the endpoint and credentials used by the following
+// requests.
+func (c *Client) SetEndpoint(endpoint, accessKeyID, accessKeySecret string) error {
+	if endpoint == "" {
+		return fmt.Errorf("endpoint is required")
+	}
+	c.setTarget(endpoint, accessKeyID, accessKeySecret)
+	return nil
+}
+
+// setTarget is SetEndpoint without validation.
+func (c *Client) setTarget(endpoint, accessKeyID, accessKeySecret string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conf.Endpoint = endpoint
+	c.conf.AccessKeyID = accessKeyID
+	c.conf.AccessKeySecret = accessKeySecret
+}
+
+// target returns the endpoint and credentials of the next request.
+func (c *Client) target() (endpoint, accessKeyID, accessKeySecret string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conf.Endpoint, c.conf.AccessKeyID, c.conf.AccessKeySecret
+}
+
+// RateLimitStats reports the usage of the configured rate limits.
+func (c *Client) RateLimitStats() []RateLimitStat {
+	return c.limiter.stats()
+}
+
+// batchUsage sums up what msgs cost to the rate limits of their types.
+func batchUsage(msgs []Message, withBytes bool) ([]rateUsage, error) {
+	var usage []rateUsage
+	index := make(map[string]int)
+	for i := range msgs {
+		size := 0
+		if withBytes {
+			var err error
+			if size, err = messageSize(&msgs[i]); err != nil {
+				return nil, err
+			}
+		}
+
+		j, ok := index[msgs[i].Type]
+		if !ok {
+			j = len(usage)
+			index[msgs[i].Type] = j
+			usage = append(usage, rateUsage{typ: msgs[i].Type})
+		}
+		usage[j].messages++
+		usage[j].bytes += size
+	}
+	return usage, nil
+}
+
+// prepare serializes and compresses messages into a request body, raw is
+// the size before compression.
+func (c *Client) prepare(messages *Messages) (data []byte, raw int, err error) {
+	data, raw, err = c.encode(messages)
+	if err != nil {
+		return nil, 0, err
+	}
+	c.metrics.prepared(len(messages.Messages), raw, len(data))
+	return data, raw, nil
+}
+
+// encode is prepare without accounting the batch in the stats, for batches
+// encoded again after some of their messages were dropped.
+func (c *Client) encode(messages *Messages) (data []byte, raw int, err error) {
+	// 序列化 && 压缩数据
+	data, err = encoding(c.conf.Encoding, &messages)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	raw = len(data)
+	if !c.conf.NoCompression {
+		data, err = c.compress(data)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return data, raw, nil
+}
+
+// send makes a single collect request with a body made by prepare.
+func (c *Client) send(ctx context.Context, data []byte, attempt AttemptInfo) error {
+	method := "POST"
+	api := "/v1/collect"
+
+	endpoint, accessKeyID, accessKeySecret := c.target()
+	req, err := http.NewRequest(method, endpoint+api, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	// Workaround 每隔20个请求清理一次连接，这样能够让每一个 ingest server 收到的请求相对均匀一点
+	if atomic.AddInt64(&c.reqCount, 1)%20 == 0 {
+		req.Close = true
+		c.httpClient.CloseIdleConnections()
+	}
+
+	req.Header.Set("Content-Type", path.Join("application", c.conf.Encoding))
+	req.Header.Set("X-Ingest-Client-ID", c.conf.ClientId)
+
+	if !c.conf.NoCompression {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	var end func(int, error)
+	if c.conf.Tracer != nil {
+		attempt.Endpoint = endpoint
+		ctx, end = c.conf.Tracer.StartAttempt(ctx, attempt, req.Header)
+	}
+
+	req = req.WithContext(ctx)
+	atomic.AddInt64(&c.metrics.inflight, 1)
+	start := time.Now()
+	err = c.doRequestWithContext(req, method, api, accessKeyID, accessKeySecret, data)
+	c.metrics.request(endpoint, attempt.BatchId, time.Since(start), err)
+	atomic.AddInt64(&c.metrics.inflight, -1)
+	if end != nil {
+		end(statusCode(err), err)
+	}
+	return err
+}
+
 func (c *Client) compress(content []byte) ([]byte, error) {
 	var buf bytes.Buffer
 	zw := gzip.NewWriter(&buf)
@@ -196,17 +354,29 @@ func encoding(encoding string, v interface{}) ([]byte, error) {
 	return data, nil
 }
 
-func (c *Client) doRequestWithContext(req *http.Request, method, api string, data []byte) error {
+func decoding(encoding string, data []byte, v interface{}) error {
+	switch encoding {
+	case "json":
+		dec := fastjson.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber() // keep integers such as user ids intact
+		return dec.Decode(v)
+	case "msgpack":
+		return msgpack.Unmarshal(data, v)
+	default:
+		return errors.New("unsupported encoding format")
+	}
+}
+
+func (c *Client) doRequestWithContext(req *http.Request, method, api, accessKeyID, accessKeySecret string, data []byte) error {
 	timestamp := fmt.Sprint(time.Now().Unix())
 	nonce := strconv.Itoa(rand.Int())
-	log := c.conf.Logger.WithField("method", method).WithField("api", api)
 
-	if c.conf.AccessKeyID != "" {
-		req.Header.Set("X-AccessKeyId", c.conf.AccessKeyID)
+	if accessKeyID != "" {
+		req.Header.Set("X-AccessKeyId", accessKeyID)
 		req.Header.Set("X-Timestamp", timestamp)
 		req.Header.Set("X-Nonce", nonce)
 
-		signature := calculateSignature(method, api, c.conf.AccessKeyID, timestamp, nonce, c.conf.AccessKeySecret, data)
+		signature := calculateSignature(method, api, accessKeyID, timestamp, nonce, accessKeySecret, data)
 		req.Header.Set("X-Signature", base64.StdEncoding.EncodeToString(signature))
 	}
 
@@ -222,15 +392,23 @@ func (c *Client) doRequestWithContext(req *http.Request, method, api string, dat
 		return err
 	}
 
-	log.WithField("status", resp.Status).
-		WithField("content", string(responseBody)).
-		Trace("got response")
+	if logEnabled(c.conf.Logger, LevelTrace) {
+		c.conf.Logger.WithField("method", method).WithField("api", api).
+			WithField("status", resp.Status).
+			WithField("content", string(responseBody)).
+			Trace("got response")
+	}
 
 	if resp.StatusCode != 200 {
 		rerr := Error{}
 		err := json.Unmarshal(responseBody, &rerr)
 		if err != nil {
-			log.WithField("err", err.Error()).WithField("content", string(responseBody)).Warn("unrecognizable response")
+			// the body ends up in the error, which failed and retried
+			// batches already log, once per RetryLogInterval for retries
+			if logEnabled(c.conf.Logger, LevelDebug) {
+				c.conf.Logger.WithField("method", method).WithField("api", api).
+					WithField("err", err.Error()).WithField("content", string(responseBody)).Debug("unrecognizable response")
+			}
 			rerr.Message = string(responseBody)
 		}
 		rerr.StatusCode = resp.StatusCode
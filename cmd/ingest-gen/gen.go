@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// generate returns the formatted Go source of the events of s.
+func generate(s *schema, schemaPath string) ([]byte, error) {
+	var body bytes.Buffer
+	needsFmt := false
+
+	body.WriteString("// Event names.\nconst (\n")
+	for _, e := range s.Events {
+		fmt.Fprintf(&body, "\tEvent%s = %q\n", e.goName, e.Name)
+	}
+	body.WriteString(")\n")
+
+	for _, e := range s.Events {
+		if generateEvent(&body, s, e) {
+			needsFmt = true
+		}
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by ingest-gen from %s. DO NOT EDIT.\n\n", filepath.Base(schemaPath))
+	fmt.Fprintf(&src, "package %s\n\nimport (\n", s.Package)
+	if needsFmt {
+		src.WriteString("\t\"fmt\"\n")
+	}
+	src.WriteString("\t\"time\"\n\n\tclient \"github.com/funny/ingest-client-go-sdk/v2\"\n)\n\n")
+	src.Write(body.Bytes())
+
+	out, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w", err)
+	}
+	return out, nil
+}
+
+// generateEvent writes the type and methods of e, it tells whether they use
+// fmt.
+func generateEvent(w *bytes.Buffer, s *schema, e event) bool {
+	usesFmt := false
+	userZero := `""`
+	if s.UserID != "string" {
+		userZero = "0"
+	}
+
+	fmt.Fprintf(w, "\n// %s is the %s event", e.goName, e.Name)
+	if e.Description != "" {
+		fmt.Fprintf(w, ": %s", oneLine(e.Description))
+	}
+	fmt.Fprintf(w, ".\ntype %s struct {\n", e.goName)
+	fmt.Fprintf(w, "\tTime time.Time `ingest:\"#time\"` // now when zero\n")
+	fmt.Fprintf(w, "\tUserID %s `ingest:\"#user_id,omitempty\"`\n\n", s.UserID)
+	for _, p := range e.Properties {
+		typ, tag := p.goType, p.Name
+		if !p.Required {
+			tag += ",omitempty"
+			if !p.list {
+				typ = "*" + typ
+			}
+		}
+		fmt.Fprintf(w, "\t%s %s `ingest:%s`", p.goName, typ, strconv.Quote(tag))
+		if p.Description != "" {
+			fmt.Fprintf(w, " // %s", oneLine(p.Description))
+		}
+		w.WriteString("\n")
+	}
+	w.WriteString("}\n")
+
+	// constructor taking the required properties
+	params := []string{"userID " + s.UserID}
+	fields := []string{"UserID: userID"}
+	for _, p := range e.Properties {
+		if p.Required {
+			param := paramName(p.goName)
+			params = append(params, param+" "+p.goType)
+			fields = append(fields, p.goName+": "+param)
+		}
+	}
+	fmt.Fprintf(w, "\n// New%s returns a %s event with its required properties.\n", e.goName, e.Name)
+	fmt.Fprintf(w, "func New%s(%s) *%s {\n\treturn &%s{%s}\n}\n", e.goName, strings.Join(params, ", "), e.goName, e.goName, strings.Join(fields, ", "))
+
+	// setters of the optional properties
+	for _, p := range e.Properties {
+		if p.Required {
+			continue
+		}
+		fmt.Fprintf(w, "\n// Set%s sets the optional property %s.\n", p.goName, p.Name)
+		if p.list {
+			fmt.Fprintf(w, "func (e *%s) Set%s(v ...%s) *%s {\n\te.%s = v\n\treturn e\n}\n", e.goName, p.goName, p.goType[2:], e.goName, p.goName)
+		} else {
+			fmt.Fprintf(w, "func (e *%s) Set%s(v %s) *%s {\n\te.%s = &v\n\treturn e\n}\n", e.goName, p.goName, p.goType, e.goName, p.goName)
+		}
+	}
+
+	fmt.Fprintf(w, "\n// Validate checks that the required properties are set and that the\n// properties with an enum have one of its values.\n")
+	fmt.Fprintf(w, "func (e *%s) Validate() error {\n", e.goName)
+	for _, p := range e.Properties {
+		field := "e." + p.goName
+		if p.Required {
+			var empty string
+			switch {
+			case p.list:
+				empty = "len(" + field + ") == 0"
+			case p.goType == "string":
+				empty = field + ` == ""`
+			case p.goType == "time.Time":
+				empty = field + ".IsZero()"
+			}
+			if empty != "" {
+				usesFmt = true
+				fmt.Fprintf(w, "\tif %s {\n\t\treturn fmt.Errorf(\"%%w: %s: %s is required\", client.ErrInvalidMessage)\n\t}\n", empty, e.Name, p.Name)
+			}
+		}
+		if len(p.Enum) == 0 {
+			continue
+		}
+		usesFmt = true
+		quoted := make([]string, len(p.Enum))
+		for i, v := range p.Enum {
+			quoted[i] = strconv.Quote(v)
+		}
+		check := func(v string) {
+			fmt.Fprintf(w, "\tswitch %s {\n\tcase %s:\n\tdefault:\n", v, strings.Join(quoted, ", "))
+			fmt.Fprintf(w, "\t\treturn fmt.Errorf(\"%%w: %s: %s %%q is not one of %s\", client.ErrInvalidMessage, %s)\n\t}\n", e.Name, p.Name, strings.Join(p.Enum, ", "), v)
+		}
+		switch {
+		case p.list:
+			fmt.Fprintf(w, "\tfor _, v := range %s {\n", field)
+			check("v")
+			w.WriteString("\t}\n")
+		case p.Required:
+			check(field)
+		default:
+			fmt.Fprintf(w, "\tif %s != nil {\n", field)
+			check("*" + field)
+			w.WriteString("\t}\n")
+		}
+	}
+	w.WriteString("\treturn nil\n}\n")
+
+	fmt.Fprintf(w, "\n// Message validates e and returns its message.\n")
+	fmt.Fprintf(w, "func (e *%s) Message() (*client.Message, error) {\n", e.goName)
+	w.WriteString("\tif err := e.Validate(); err != nil {\n\t\treturn nil, err\n\t}\n")
+	fmt.Fprintf(w, "\tb := client.NewEvent(Event%s).At(e.Time)\n", e.goName)
+	fmt.Fprintf(w, "\tif e.UserID != %s {\n\t\tb.User(e.UserID)\n\t}\n", userZero)
+	for _, p := range e.Properties {
+		switch {
+		case p.Required:
+			fmt.Fprintf(w, "\tb.Prop(%q, e.%s)\n", p.Name, p.goName)
+		case p.list:
+			fmt.Fprintf(w, "\tif len(e.%s) > 0 {\n\t\tb.Prop(%q, e.%s)\n\t}\n", p.goName, p.Name, p.goName)
+		default:
+			fmt.Fprintf(w, "\tif e.%s != nil {\n\t\tb.Prop(%q, *e.%s)\n\t}\n", p.goName, p.Name, p.goName)
+		}
+	}
+	w.WriteString("\treturn b.Build()\n}\n")
+	return usesFmt
+}
+
+// paramName turns a field name into a parameter name, such as IP into ip and
+// LevelUp into levelUp.
+func paramName(field string) string {
+	n := 0
+	for n < len(field) && field[n] >= 'A' && field[n] <= 'Z' {
+		n++
+	}
+	// keep the upper case letter starting the next word of an initialism
+	if n > 1 && n < len(field) {
+		n--
+	}
+	name := strings.ToLower(field[:n]) + field[n:]
+	if token := name; isKeyword(token) || token == "userID" || token == "e" {
+		name += "_"
+	}
+	return name
+}
+
+func isKeyword(s string) bool {
+	switch s {
+	case "break", "case", "chan", "const", "continue", "default", "defer", "else", "fallthrough", "for",
+		"func", "go", "goto", "if", "import", "interface", "map", "package", "range", "return",
+		"select", "struct", "switch", "type", "var":
+		return true
+	}
+	return false
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestGenerate checks that internal/example is up to date, the package
+// compiling the generated code and testing it.
+func TestGenerate(t *testing.T) {
+	data, err := os.ReadFile("internal/example/events.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := parseSchema(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(s, "events.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("internal/example/events_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, want) {
+		t.Fatal("internal/example/events_gen.go is out of date, run go generate ./cmd/ingest-gen/...")
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	for _, c := range []struct{ schema, err string }{
+		{"events: []", "no events"},
+		{"user_id: float\nevents: [{name: a}]", "neither string nor int64"},
+		{"events: [{name: a}, {name: a}]", "defined twice"},
+		{"events: [{name: 1a}]", "not valid"},
+		{"events: [{name: a, properties: [{name: user_id, type: string}]}]", "clashes"},
+		{"events: [{name: a, properties: [{name: b, type: map}]}]", "unknown type"},
+		{"events: [{name: a, properties: [{name: b, type: int, enum: [1]}]}]", "not a string"},
+		{"events: [{name: a, props: []}]", "not found"},
+	} {
+		_, err := parseSchema([]byte(c.schema))
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q: expected error containing %q, got %v", c.schema, c.err, err)
+		}
+	}
+}
+
+func TestParamName(t *testing.T) {
+	for field, want := range map[string]string{"IP": "ip", "LevelUp": "levelUp", "IPAddress": "ipAddress", "Type": "type_", "OrderID": "orderID"} {
+		if got := paramName(field); got != want {
+			t.Errorf("paramName(%s) = %s, want %s", field, got, want)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+// Package example holds the events generated from events.yaml, it is used by
+// the tests of ingest-gen.
+package example
+
+//go:generate go run github.com/funny/ingest-client-go-sdk/v2/cmd/ingest-gen -schema events.yaml -out events_gen.go
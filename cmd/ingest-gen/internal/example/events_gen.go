@@ -0,0 +1,155 @@
+// Code generated by ingest-gen from events.yaml. DO NOT EDIT.
+
+package example
+
+import (
+	"fmt"
+	"time"
+
+	client "github.com/funny/ingest-client-go-sdk/v2"
+)
+
+// Event names.
+const (
+	EventLogin    = "login"
+	EventPurchase = "purchase"
+)
+
+// Login is the login event: a player logged in.
+type Login struct {
+	Time   time.Time `ingest:"#time"` // now when zero
+	UserID int64     `ingest:"#user_id,omitempty"`
+
+	IP      string  `ingest:"ip"`
+	Level   *int64  `ingest:"level,omitempty"`
+	Channel *string `ingest:"channel,omitempty"`
+}
+
+// NewLogin returns a login event with its required properties.
+func NewLogin(userID int64, ip string) *Login {
+	return &Login{UserID: userID, IP: ip}
+}
+
+// SetLevel sets the optional property level.
+func (e *Login) SetLevel(v int64) *Login {
+	e.Level = &v
+	return e
+}
+
+// SetChannel sets the optional property channel.
+func (e *Login) SetChannel(v string) *Login {
+	e.Channel = &v
+	return e
+}
+
+// Validate checks that the required properties are set and that the
+// properties with an enum have one of its values.
+func (e *Login) Validate() error {
+	if e.IP == "" {
+		return fmt.Errorf("%w: login: ip is required", client.ErrInvalidMessage)
+	}
+	if e.Channel != nil {
+		switch *e.Channel {
+		case "appstore", "googleplay":
+		default:
+			return fmt.Errorf("%w: login: channel %q is not one of appstore, googleplay", client.ErrInvalidMessage, *e.Channel)
+		}
+	}
+	return nil
+}
+
+// Message validates e and returns its message.
+func (e *Login) Message() (*client.Message, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	b := client.NewEvent(EventLogin).At(e.Time)
+	if e.UserID != 0 {
+		b.User(e.UserID)
+	}
+	b.Prop("ip", e.IP)
+	if e.Level != nil {
+		b.Prop("level", *e.Level)
+	}
+	if e.Channel != nil {
+		b.Prop("channel", *e.Channel)
+	}
+	return b.Build()
+}
+
+// Purchase is the purchase event: a player bought items.
+type Purchase struct {
+	Time   time.Time `ingest:"#time"` // now when zero
+	UserID int64     `ingest:"#user_id,omitempty"`
+
+	OrderID  string     `ingest:"order_id"`
+	Amount   float64    `ingest:"amount"` // price paid, in the currency
+	Currency string     `ingest:"currency"`
+	Items    []string   `ingest:"items,omitempty"`
+	PaidAt   *time.Time `ingest:"paid_at,omitempty"`
+	First    *bool      `ingest:"first,omitempty"`
+}
+
+// NewPurchase returns a purchase event with its required properties.
+func NewPurchase(userID int64, orderID string, amount float64, currency string) *Purchase {
+	return &Purchase{UserID: userID, OrderID: orderID, Amount: amount, Currency: currency}
+}
+
+// SetItems sets the optional property items.
+func (e *Purchase) SetItems(v ...string) *Purchase {
+	e.Items = v
+	return e
+}
+
+// SetPaidAt sets the optional property paid_at.
+func (e *Purchase) SetPaidAt(v time.Time) *Purchase {
+	e.PaidAt = &v
+	return e
+}
+
+// SetFirst sets the optional property first.
+func (e *Purchase) SetFirst(v bool) *Purchase {
+	e.First = &v
+	return e
+}
+
+// Validate checks that the required properties are set and that the
+// properties with an enum have one of its values.
+func (e *Purchase) Validate() error {
+	if e.OrderID == "" {
+		return fmt.Errorf("%w: purchase: order_id is required", client.ErrInvalidMessage)
+	}
+	if e.Currency == "" {
+		return fmt.Errorf("%w: purchase: currency is required", client.ErrInvalidMessage)
+	}
+	switch e.Currency {
+	case "CNY", "USD":
+	default:
+		return fmt.Errorf("%w: purchase: currency %q is not one of CNY, USD", client.ErrInvalidMessage, e.Currency)
+	}
+	return nil
+}
+
+// Message validates e and returns its message.
+func (e *Purchase) Message() (*client.Message, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	b := client.NewEvent(EventPurchase).At(e.Time)
+	if e.UserID != 0 {
+		b.User(e.UserID)
+	}
+	b.Prop("order_id", e.OrderID)
+	b.Prop("amount", e.Amount)
+	b.Prop("currency", e.Currency)
+	if len(e.Items) > 0 {
+		b.Prop("items", e.Items)
+	}
+	if e.PaidAt != nil {
+		b.Prop("paid_at", *e.PaidAt)
+	}
+	if e.First != nil {
+		b.Prop("first", *e.First)
+	}
+	return b.Build()
+}
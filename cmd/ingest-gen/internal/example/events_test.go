@@ -0,0 +1,37 @@
+package example
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	client "github.com/funny/ingest-client-go-sdk/v2"
+)
+
+func TestMessage(t *testing.T) {
+	at := time.UnixMilli(1728904200000)
+	e := NewPurchase(42, "o-1", 6.5, "USD").SetItems("sword", "shield").SetFirst(true)
+	e.Time = at
+	msg, err := e.Message()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := msg.Data.(map[string]interface{})
+	if data[client.FieldEvent] != EventPurchase || data[client.FieldTime] != int64(1728904200000) || data[client.FieldUserID] != int64(42) ||
+		data["amount"] != 6.5 || data["first"] != true || len(data["items"].([]string)) != 2 {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	if _, ok := data["paid_at"]; ok {
+		t.Fatal("unset optional property paid_at in message")
+	}
+
+	for _, e := range []interface{ Validate() error }{
+		NewLogin(42, ""),
+		NewLogin(42, "127.0.0.1").SetChannel("steam"),
+		NewPurchase(42, "o-1", 6.5, "EUR"),
+	} {
+		if err := e.Validate(); !errors.Is(err, client.ErrInvalidMessage) {
+			t.Errorf("expected ErrInvalidMessage, got %v", err)
+		}
+	}
+}
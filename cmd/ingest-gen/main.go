@@ -0,0 +1,73 @@
+// Command ingest-gen generates typed Go constructors for the events of a
+// YAML event catalog:
+//
+//	package: events
+//	user_id: int64            # type of #user_id, string or int64, default is string
+//	events:
+//	  - name: login
+//	    description: a player logged in
+//	    properties:
+//	      - name: ip
+//	        type: string
+//	        required: true
+//	      - name: level
+//	        type: int
+//	      - name: channel
+//	        type: string
+//	        enum: [appstore, googleplay]
+//
+// Each event gets a struct with a field per property, a constructor taking
+// the required properties, Validate and Message methods building the
+// client.Message. The structs are also tagged for client.TypedClient.
+// Property types are string, int, float, bool, time, and lists of them
+// such as []string.
+//
+// It is a separate module so that the client does not depend on YAML:
+//
+//	//go:generate ingest-gen -schema events.yaml -out events_gen.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	schemaPath := flag.String("schema", "", "event schema file")
+	out := flag.String("out", "", "generated file, default is stdout")
+	pkg := flag.String("package", "", "package of the generated file, overrides the schema")
+	flag.Parse()
+
+	if err := run(*schemaPath, *out, *pkg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(schemaPath, out, pkg string) error {
+	if schemaPath == "" {
+		return fmt.Errorf("-schema is required")
+	}
+	data, err := os.ReadFile(schemaPath)
+	if err != nil {
+		return err
+	}
+	s, err := parseSchema(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", schemaPath, err)
+	}
+	if pkg != "" {
+		s.Package = pkg
+	}
+
+	src, err := generate(s, schemaPath)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(out, src, 0644)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type schema struct {
+	Package string  `yaml:"package"`
+	UserID  string  `yaml:"user_id"` // string or int64
+	Events  []event `yaml:"events"`
+}
+
+type event struct {
+	Name        string     `yaml:"name"`
+	Description string     `yaml:"description"`
+	Properties  []property `yaml:"properties"`
+
+	goName string
+}
+
+type property struct {
+	Name        string   `yaml:"name"`
+	Type        string   `yaml:"type"`
+	Required    bool     `yaml:"required"`
+	Description string   `yaml:"description"`
+	Enum        []string `yaml:"enum"`
+
+	goName string
+	goType string // of the value, without the pointer of optional scalars
+	list   bool
+}
+
+// scalarTypes maps the property types of the schema to Go types.
+var scalarTypes = map[string]string{
+	"string": "string",
+	"int":    "int64",
+	"float":  "float64",
+	"bool":   "bool",
+	"time":   "time.Time",
+}
+
+var nameRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+
+func parseSchema(data []byte) (*schema, error) {
+	var s schema
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil {
+		return nil, err
+	}
+
+	if s.Package == "" {
+		s.Package = "events"
+	}
+	if !token.IsIdentifier(s.Package) {
+		return nil, fmt.Errorf("package %q is not a valid identifier", s.Package)
+	}
+	switch s.UserID {
+	case "":
+		s.UserID = "string"
+	case "string", "int64":
+	default:
+		return nil, fmt.Errorf("user_id type %q is neither string nor int64", s.UserID)
+	}
+	if len(s.Events) == 0 {
+		return nil, fmt.Errorf("no events")
+	}
+
+	events := make(map[string]bool)
+	for i := range s.Events {
+		e := &s.Events[i]
+		if !nameRe.MatchString(e.Name) {
+			return nil, fmt.Errorf("event name %q is not valid", e.Name)
+		}
+		e.goName = goName(e.Name)
+		if events[e.Name] || events[e.goName] {
+			return nil, fmt.Errorf("event %q is defined twice", e.Name)
+		}
+		events[e.Name], events[e.goName] = true, true
+
+		// Time and UserID are the fields of the reserved properties
+		fields := map[string]bool{"Time": true, "UserID": true}
+		for j := range e.Properties {
+			p := &e.Properties[j]
+			if !nameRe.MatchString(p.Name) {
+				return nil, fmt.Errorf("event %s: property name %q is not valid", e.Name, p.Name)
+			}
+			p.goName = goName(p.Name)
+			if fields[p.goName] {
+				return nil, fmt.Errorf("event %s: property %q clashes with another property or a reserved one", e.Name, p.Name)
+			}
+			fields[p.goName] = true
+
+			typ := p.Type
+			if strings.HasPrefix(typ, "[]") {
+				p.list = true
+				typ = typ[2:]
+			}
+			goType, ok := scalarTypes[typ]
+			if !ok {
+				return nil, fmt.Errorf("event %s: property %s has unknown type %q", e.Name, p.Name, p.Type)
+			}
+			if p.list {
+				goType = "[]" + goType
+			}
+			p.goType = goType
+			if len(p.Enum) > 0 && typ != "string" {
+				return nil, fmt.Errorf("event %s: property %s has an enum but is not a string", e.Name, p.Name)
+			}
+		}
+	}
+	return &s, nil
+}
+
+// initialisms are written upper case in Go names, like golint wants.
+var initialisms = map[string]bool{
+	"API": true, "HTTP": true, "ID": true, "IP": true, "JSON": true, "SDK": true,
+	"UI": true, "URL": true, "UUID": true, "VIP": true,
+}
+
+// goName turns a name such as level_up or user-id into LevelUp and UserID.
+func goName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' || r == '.' }) {
+		if upper := strings.ToUpper(part); initialisms[upper] {
+			b.WriteString(upper)
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"log/slog"
+
+	client "github.com/funny/ingest-client-go-sdk/v2"
+)
+
+const usage = `usage: ingest-replay <command> [flags]
+
+commands:
+  list    list dead-lettered batches
+  resend  resend dead-lettered batches and remove the ones delivered
+`
+
+type filter struct {
+	batchId string
+	errText string
+	status  int
+	since   time.Duration
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	dir := fs.String("dir", "", "dead letter directory")
+	f := filter{}
+	fs.StringVar(&f.batchId, "batch-id", "", "only batches with this batch id")
+	fs.StringVar(&f.errText, "error", "", "only batches whose error contains this text")
+	fs.IntVar(&f.status, "status", 0, "only batches rejected with this http status code")
+	fs.DurationVar(&f.since, "since", 0, "only batches failed within this duration")
+
+	var err error
+	switch os.Args[1] {
+	case "list":
+		fs.Parse(os.Args[2:])
+		err = list(*dir, f)
+	case "resend":
+		endpoint := fs.String("endpoint", "", "")
+		accessKeyId := fs.String("access-key-id", "", "")
+		accessKeySecret := fs.String("access-key-secret", "", "")
+		timeout := fs.Duration("timeout", 30*time.Second, "time allowed to resend each batch")
+		dryRun := fs.Bool("dry-run", false, "print the batches that would be resent")
+		fs.Parse(os.Args[2:])
+
+		config := client.Config{
+			Endpoint:        *endpoint,
+			AccessKeyID:     *accessKeyId,
+			AccessKeySecret: *accessKeySecret,
+		}
+		err = resend(*dir, f, config, *timeout, *dryRun)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func load(dir string, f filter) ([]*client.DeadLetter, *client.FileDeadLetterSink, error) {
+	if dir == "" {
+		return nil, nil, fmt.Errorf("-dir is required")
+	}
+	sink := &client.FileDeadLetterSink{Dir: dir}
+
+	dls, err := sink.List()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read dead letters: %s", err)
+	}
+
+	var out []*client.DeadLetter
+	for _, dl := range dls {
+		if f.batchId != "" && dl.BatchId != f.batchId {
+			continue
+		}
+		if f.errText != "" && !strings.Contains(dl.Error, f.errText) {
+			continue
+		}
+		if f.status != 0 && dl.StatusCode != f.status {
+			continue
+		}
+		if f.since != 0 && time.Since(dl.FailedAt) > f.since {
+			continue
+		}
+		out = append(out, dl)
+	}
+	return out, sink, nil
+}
+
+func list(dir string, f filter) error {
+	dls, _, err := load(dir, f)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BATCH ID\tMESSAGES\tSTATUS\tFAILED AT\tERROR")
+	for _, dl := range dls {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", dl.BatchId, len(dl.Messages), dl.StatusCode, dl.FailedAt.Format(time.RFC3339), dl.Error)
+	}
+	return w.Flush()
+}
+
+func resend(dir string, f filter, config client.Config, timeout time.Duration, dryRun bool) error {
+	dls, sink, err := load(dir, f)
+	if err != nil {
+		return err
+	}
+
+	c, err := client.NewClient(config)
+	if err != nil {
+		return fmt.Errorf("create client: %s", err)
+	}
+
+	failed := 0
+	for _, dl := range dls {
+		if dryRun {
+			slog.Info("would resend batch", "batchId", dl.BatchId, "messages", len(dl.Messages))
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := c.Collect(ctx, &client.Messages{BatchId: dl.BatchId, Messages: dl.Messages})
+		cancel()
+		if err != nil {
+			slog.Error("unable to resend batch", "batchId", dl.BatchId, "err", err)
+			failed++
+			continue
+		}
+
+		if err := sink.Remove(dl); err != nil {
+			return fmt.Errorf("remove dead letter %s: %s", dl.BatchId, err)
+		}
+		slog.Info("batch resent", "batchId", dl.BatchId, "messages", len(dl.Messages))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d batches could not be resent", failed, len(dls))
+	}
+	return nil
+}
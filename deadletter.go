@@ -0,0 +1,136 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DeadLetter is a batch that failed permanently, along with why and when.
+type DeadLetter struct {
+	BatchId    string    `json:"batchId"`
+	Error      string    `json:"error"`
+	StatusCode int       `json:"statusCode,omitempty"` // http status code returned by ingest, if any
+	CreatedAt  time.Time `json:"createdAt"`            // when the batch was sealed
+	FailedAt   time.Time `json:"failedAt"`
+	Messages   []Message `json:"messages"`
+
+	File string `json:"-"` // file the dead letter was read from, set by FileDeadLetterSink.List
+}
+
+// DeadLetterSink receives batches a BufferedClient gave up on.
+type DeadLetterSink interface {
+	WriteDeadLetter(dl *DeadLetter) error
+}
+
+const deadLetterExt = ".json"
+
+// FileDeadLetterSink stores every dead letter as a JSON file in Dir.
+type FileDeadLetterSink struct {
+	Dir string
+}
+
+var _ DeadLetterSink = &FileDeadLetterSink{}
+
+func NewFileDeadLetterSink(dir string) (*FileDeadLetterSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create dead letter dir: %w", err)
+	}
+	return &FileDeadLetterSink{Dir: dir}, nil
+}
+
+func (s *FileDeadLetterSink) WriteDeadLetter(dl *DeadLetter) error {
+	data, err := fastjson.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s%s", dl.FailedAt.UnixMilli(), sanitizeFileName(dl.BatchId), deadLetterExt)
+	tmp, err := os.CreateTemp(s.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.Dir, name))
+}
+
+// List reads all dead letters in Dir, oldest first.
+func (s *FileDeadLetterSink) List() ([]*DeadLetter, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var dls []*DeadLetter
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, deadLetterExt) {
+			continue
+		}
+
+		path := filepath.Join(s.Dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		dl := &DeadLetter{}
+		if err := decoding("json", data, dl); err != nil {
+			return nil, fmt.Errorf("read dead letter %s: %w", name, err)
+		}
+		dl.File = path
+		dls = append(dls, dl)
+	}
+
+	sort.SliceStable(dls, func(i, j int) bool { return dls[i].FailedAt.Before(dls[j].FailedAt) })
+	return dls, nil
+}
+
+// Remove deletes a dead letter returned by List, typically after it has been
+// resent.
+func (s *FileDeadLetterSink) Remove(dl *DeadLetter) error {
+	if dl.File == "" {
+		return errors.New("dead letter was not read from a file")
+	}
+	return os.Remove(dl.File)
+}
+
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func newDeadLetter(b *batch, err error) *DeadLetter {
+	dl := &DeadLetter{
+		BatchId:   b.BatchId,
+		Error:     err.Error(),
+		CreatedAt: b.createdAt,
+		FailedAt:  time.Now(),
+		Messages:  b.Messages.Messages,
+	}
+
+	var ierr Error
+	if errors.As(err, &ierr) {
+		dl.StatusCode = ierr.StatusCode
+	}
+	return dl
+}
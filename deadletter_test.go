@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterSink(t *testing.T) {
+	srv := newFakeIngest(t)
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": "bad credentials"}`))
+	})
+
+	sink, err := NewFileDeadLetterSink(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := testBufferedConfig(srv.URL)
+	conf.DeadLetterSink = sink
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bc.SendAndWait(ctx, newTestMessage(7)); err == nil {
+		t.Fatal("expected send to fail")
+	}
+	if err := bc.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	dls, err := sink.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(dls))
+	}
+	dl := dls[0]
+	if dl.StatusCode != http.StatusUnauthorized || dl.BatchId == "" || len(dl.Messages) != 1 || dl.CreatedAt.IsZero() {
+		t.Fatalf("unexpected dead letter %+v", dl)
+	}
+
+	if err := sink.Remove(dl); err != nil {
+		t.Fatal(err)
+	}
+	if dls, _ := sink.List(); len(dls) != 0 {
+		t.Fatalf("expected dead letter to be removed, got %d", len(dls))
+	}
+}
+
+func TestDeadLetterOnCloseDeadline(t *testing.T) {
+	srv := newFakeIngest(t)
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	sink, err := NewFileDeadLetterSink(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := testBufferedConfig(srv.URL)
+	conf.MaxMessagesPerBatch = 2
+	conf.DeadLetterSink = sink
+	conf.ReturnUndelivered = true
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := bc.Send(context.Background(), newTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var uerr *UndeliveredError
+	if err := bc.Close(ctx); !errors.As(err, &uerr) {
+		t.Fatalf("expected UndeliveredError, got %v", err)
+	}
+
+	dls, err := sink.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := 0
+	for _, dl := range dls {
+		messages += len(dl.Messages)
+	}
+	if len(dls) != uerr.Batches || messages != 5 {
+		t.Fatalf("expected the 5 undelivered messages to be dead-lettered, got %d in %d dead letters", messages, len(dls))
+	}
+	// dead-lettered messages are not handed back a second time
+	if uerr.Messages != 5 || len(uerr.Undelivered) != 0 {
+		t.Fatalf("expected 5 undelivered messages reported but none returned, got %d and %d", uerr.Messages, len(uerr.Undelivered))
+	}
+}
@@ -0,0 +1,277 @@
+package client
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxDebugBatches bounds the pending batches listed by the debug handler.
+const maxDebugBatches = 200
+
+// DebugInfo is the live state of a BufferedClient shown by DebugHandler.
+type DebugInfo struct {
+	Time   time.Time
+	State  string // running, draining or closed
+	Paused bool
+
+	Config       DebugConfig
+	Stats        Stats
+	Shards       []ShardInfo
+	Batches      []PendingBatchInfo // oldest first, at most 200
+	RecentErrors []RequestError     // most recent first
+}
+
+// DebugConfig is the configuration of a BufferedClient as currently in
+// effect, with secrets redacted.
+type DebugConfig struct {
+	Endpoint        string
+	AccessKeyID     string
+	AccessKeySecret string // redacted
+	ClientId        string
+
+	Encoding                 string
+	Compression              string // empty when turned off
+	RetryTimeIntervalInitial time.Duration
+	RetryTimeIntervalMax     time.Duration
+	MaxMessagesPerBatch      int
+	MaxDurationPerBatch      time.Duration
+	MaxConcurrency           int
+	MinConcurrency           int
+	AdaptiveConcurrency      bool
+	Partitions               int // zero when not partitioned
+	BatchingShards           int
+	MaxQueueMessages         int
+	MaxQueueBytes            int
+	OverflowPolicy           string
+	MaxBufferedBytes         int64
+	MaxMessageAge            time.Duration
+	SpoolDir                 string
+	DeadLetterSink           bool
+	ReturnUndelivered        bool
+}
+
+// ShardInfo is the depth of the queue of a batching shard.
+type ShardInfo struct {
+	Id             int
+	QueuedMessages int
+	QueuedBytes    int // only counted when message sizes are computed
+}
+
+// PendingBatchInfo describes a sealed batch not resolved yet.
+type PendingBatchInfo struct {
+	BatchId     string
+	Messages    int
+	Priority    string
+	State       string // ready, in flight or retrying
+	Attempts    int
+	Age         time.Duration // since its first message was batched
+	NextAttempt time.Time     // when retrying
+	LastError   string
+}
+
+// Debug returns a snapshot of the live state of the client, as shown by
+// DebugHandler.
+func (bc *BufferedClient) Debug() DebugInfo {
+	now := time.Now()
+	info := DebugInfo{
+		Time:         now,
+		State:        stateName(atomic.LoadInt32(&bc.state)),
+		Paused:       bc.Paused(),
+		Config:       bc.debugConfig(),
+		Stats:        bc.Stats(),
+		RecentErrors: bc.client.metrics.recentErrors(),
+	}
+	for _, s := range bc.shards {
+		items, bytes := s.queue.size()
+		info.Shards = append(info.Shards, ShardInfo{Id: s.id, QueuedMessages: items, QueuedBytes: bytes})
+	}
+
+	bc.pendingMu.Lock()
+	batches := make([]*batch, 0, len(bc.pending))
+	for b := range bc.pending {
+		batches = append(batches, b)
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].createdAt.Before(batches[j].createdAt) })
+	if len(batches) > maxDebugBatches {
+		batches = batches[:maxDebugBatches]
+	}
+	for _, b := range batches {
+		pb := PendingBatchInfo{
+			BatchId:   b.BatchId,
+			Messages:  b.len(),
+			Priority:  b.prio.String(),
+			State:     "ready",
+			Attempts:  b.attempts,
+			Age:       now.Sub(b.createdAt),
+			LastError: b.lastErr,
+		}
+		switch {
+		case b.inflight:
+			pb.State = "in flight"
+		case b.attempts > 0:
+			pb.State = "retrying"
+			pb.NextAttempt = b.nextAttempt
+		}
+		info.Batches = append(info.Batches, pb)
+	}
+	bc.pendingMu.Unlock()
+	return info
+}
+
+func (bc *BufferedClient) debugConfig() DebugConfig {
+	endpoint, accessKeyID, accessKeySecret := bc.client.target()
+	if accessKeySecret != "" {
+		accessKeySecret = "REDACTED"
+	}
+
+	bc.reconfigureMu.Lock()
+	defer bc.reconfigureMu.Unlock()
+
+	c := bc.conf
+	dc := DebugConfig{
+		Endpoint:                 endpoint,
+		AccessKeyID:              accessKeyID,
+		AccessKeySecret:          accessKeySecret,
+		ClientId:                 c.ClientId,
+		Encoding:                 bc.client.conf.Encoding,
+		RetryTimeIntervalInitial: c.RetryTimeIntervalInitial,
+		RetryTimeIntervalMax:     c.RetryTimeIntervalMax,
+		MaxMessagesPerBatch:      c.MaxMessagesPerBatch,
+		MaxDurationPerBatch:      c.MaxDurationPerBatch,
+		MaxConcurrency:           c.MaxConcurrency,
+		MinConcurrency:           c.MinConcurrency,
+		AdaptiveConcurrency:      c.AdaptiveConcurrency,
+		BatchingShards:           c.BatchingShards,
+		MaxQueueMessages:         c.MaxQueueMessages,
+		MaxQueueBytes:            c.MaxQueueBytes,
+		OverflowPolicy:           c.OverflowPolicy.String(),
+		MaxBufferedBytes:         c.MaxBufferedBytes,
+		MaxMessageAge:            c.MaxMessageAge,
+		SpoolDir:                 c.SpoolDir,
+		DeadLetterSink:           c.DeadLetterSink != nil,
+		ReturnUndelivered:        c.ReturnUndelivered,
+	}
+	if !bc.client.conf.NoCompression {
+		dc.Compression = bc.client.conf.CompressionAlgo
+	}
+	if c.PartitionKey != nil {
+		dc.Partitions = c.Partitions
+	}
+	return dc
+}
+
+func stateName(state int32) string {
+	switch state {
+	case stateRunning:
+		return "running"
+	case stateDraining:
+		return "draining"
+	default:
+		return "closed"
+	}
+}
+
+// DebugHandler returns a handler showing the live state of the client, meant
+// to be mounted on an admin port. It serves an HTML page, or JSON when the
+// request has format=json in its query or accepts application/json. Secrets
+// are redacted, but message types, batch IDs and errors are shown as is.
+func (bc *BufferedClient) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := bc.Debug()
+		w.Header().Set("Cache-Control", "no-store")
+
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(info); err != nil {
+				bc.conf.Logger.WithField("err", err.Error()).Warn("unable to write debug info")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := debugTemplate.Execute(w, info); err != nil {
+			bc.conf.Logger.WithField("err", err.Error()).Warn("unable to write debug page")
+		}
+	})
+}
+
+var debugTemplate = template.Must(template.New("debug").Funcs(template.FuncMap{
+	"round": func(d time.Duration) time.Duration { return d.Round(time.Millisecond) },
+	"mean": func(h LatencyHistogram) time.Duration {
+		if h.Count == 0 {
+			return 0
+		}
+		return (h.Sum / time.Duration(h.Count)).Round(time.Millisecond)
+	},
+	"until": func(now, t time.Time) time.Duration { return t.Sub(now).Round(time.Millisecond) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ingest client</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+</style>
+</head>
+<body>
+<h1>ingest client</h1>
+<p>{{.State}}{{if .Paused}}, paused{{end}} at {{.Time.Format "2006-01-02 15:04:05.000"}}, <a href="?format=json">json</a></p>
+
+<h2>Endpoint</h2>
+<table>
+<tr><th>endpoint</th><td>{{.Config.Endpoint}}</td></tr>
+<tr><th>concurrency</th><td>{{.Stats.ConcurrencyLimit}} of {{.Config.MaxConcurrency}}{{if .Config.AdaptiveConcurrency}}, adaptive from {{.Config.MinConcurrency}}{{end}}</td></tr>
+<tr><th>requests in flight</th><td>{{.Stats.BatchesInFlight}}</td></tr>
+<tr><th>requests</th><td>{{.Stats.Requests}}, {{.Stats.Retries}} retries</td></tr>
+<tr><th>by status</th><td>{{range $status, $n := .Stats.RequestsByStatus}}{{$status}}: {{$n}} {{end}}</td></tr>
+<tr><th>mean latency</th><td>{{range $endpoint, $h := .Stats.Latency}}{{$endpoint}}: {{mean $h}} {{end}}</td></tr>
+<tr><th>last error</th><td>{{if .Stats.LastError}}{{.Stats.LastError}} at {{.Stats.LastErrorTime.Format "15:04:05.000"}}{{end}}</td></tr>
+</table>
+
+<h2>Queues</h2>
+<table>
+<tr><th>shard</th><th>messages</th><th>bytes</th></tr>
+{{range .Shards}}<tr><td>{{.Id}}</td><td>{{.QueuedMessages}}</td><td>{{.QueuedBytes}}</td></tr>
+{{end}}</table>
+<p>{{.Stats.PendingBatches}} pending batches, {{.Stats.RetryingBatches}} retrying, {{.Stats.BufferedBytes}} buffered bytes,
+{{.Stats.MessagesSent}} messages sent, {{.Stats.MessagesFailed}} failed, {{.Stats.MessagesDropped}} dropped</p>
+
+<h2>Pending batches</h2>
+<table>
+<tr><th>batch</th><th>messages</th><th>priority</th><th>state</th><th>attempts</th><th>age</th><th>next attempt</th><th>last error</th></tr>
+{{$now := .Time}}{{range .Batches}}<tr><td>{{.BatchId}}</td><td>{{.Messages}}</td><td>{{.Priority}}</td><td>{{.State}}</td><td>{{.Attempts}}</td><td>{{round .Age}}</td><td>{{if not .NextAttempt.IsZero}}in {{until $now .NextAttempt}}{{end}}</td><td>{{.LastError}}</td></tr>
+{{end}}</table>
+
+<h2>Recent errors</h2>
+<table>
+<tr><th>time</th><th>endpoint</th><th>batch</th><th>status</th><th>error</th></tr>
+{{range .RecentErrors}}<tr><td>{{.Time.Format "15:04:05.000"}}</td><td>{{.Endpoint}}</td><td>{{.BatchId}}</td><td>{{.StatusCode}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+
+<h2>Config</h2>
+<table>
+<tr><th>access key</th><td>{{.Config.AccessKeyID}} / {{.Config.AccessKeySecret}}</td></tr>
+<tr><th>client id</th><td>{{.Config.ClientId}}</td></tr>
+<tr><th>encoding</th><td>{{.Config.Encoding}}{{if .Config.Compression}}, {{.Config.Compression}}{{end}}</td></tr>
+<tr><th>batch</th><td>{{.Config.MaxMessagesPerBatch}} messages, {{.Config.MaxDurationPerBatch}}</td></tr>
+<tr><th>retry interval</th><td>{{.Config.RetryTimeIntervalInitial}} to {{.Config.RetryTimeIntervalMax}}</td></tr>
+<tr><th>partitions</th><td>{{.Config.Partitions}}</td></tr>
+<tr><th>shards</th><td>{{.Config.BatchingShards}}</td></tr>
+<tr><th>queue</th><td>{{.Config.MaxQueueMessages}} messages, {{.Config.MaxQueueBytes}} bytes, {{.Config.OverflowPolicy}}</td></tr>
+<tr><th>max buffered bytes</th><td>{{.Config.MaxBufferedBytes}}</td></tr>
+<tr><th>max message age</th><td>{{.Config.MaxMessageAge}}</td></tr>
+<tr><th>spool</th><td>{{.Config.SpoolDir}}</td></tr>
+<tr><th>dead letter sink</th><td>{{.Config.DeadLetterSink}}</td></tr>
+</table>
+</body>
+</html>
+`))
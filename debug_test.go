@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugHandler(t *testing.T) {
+	srv := newFakeIngest(t)
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	conf := testBufferedConfig(srv.URL)
+	conf.RetryTimeIntervalMax = time.Second
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		bc.Close(ctx)
+	}()
+
+	bc.Send(context.Background(), newTestMessage(0))
+	for len(bc.client.metrics.recentErrors()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	h := bc.DebugHandler()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/?format=json", nil))
+	var info DebugInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.State != "running" || info.Config.AccessKeySecret != "REDACTED" || info.Config.Endpoint != srv.URL {
+		t.Fatalf("unexpected debug info: %+v", info)
+	}
+	if len(info.Batches) != 1 || info.Batches[0].Attempts == 0 || info.Batches[0].LastError == "" {
+		t.Fatalf("expected the failing batch to be listed: %+v", info.Batches)
+	}
+	if len(info.RecentErrors) == 0 || info.RecentErrors[0].StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected recent errors: %+v", info.RecentErrors)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, info.Batches[0].BatchId) || strings.Contains(body, conf.AccessKeySecret) {
+		t.Fatalf("unexpected debug page:\n%s", body)
+	}
+}
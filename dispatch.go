@@ -0,0 +1,182 @@
+package client
+
+import "sync"
+
+// dispatcher holds sealed batches until the sending loop has a request slot
+// for them. Higher priority batches go first, and a partition lane never has
+// more than one batch ready or in flight; the following ones wait in the lane
+// backlog until it is settled.
+//
+// The batching loop stops taking the messages of a priority class while the
+// class has maxReady batches ready, which leaves backpressure and shedding to
+// the queue. Each class has its own limit so that batches waiting for the
+// slots of a lower class never keep a higher class from being batched. In
+// the same way it holds the messages of a lane whose backlog is full without
+// slowing down the other lanes.
+type dispatcher struct {
+	maxReady int // per priority class
+
+	mu        sync.Mutex
+	ready     [numPriorities][]*batch
+	nready    int
+	lanes     []lane
+	busyLanes int
+
+	wakeCh  chan struct{} // signaled when a batch may be dispatched
+	spaceCh chan struct{} // closed and replaced when a full class or lane gets room
+}
+
+// lane is the sending state of a partition.
+type lane struct {
+	busy    bool // a batch of the lane is ready or in flight
+	backlog []*batch
+}
+
+func newDispatcher(maxReady, lanes int) *dispatcher {
+	return &dispatcher{
+		maxReady: maxReady,
+		lanes:    make([]lane, lanes),
+		wakeCh:   make(chan struct{}, 1),
+		spaceCh:  make(chan struct{}),
+	}
+}
+
+// push adds a sealed batch, it never blocks.
+func (d *dispatcher) push(b *batch) {
+	d.mu.Lock()
+	if b.lane != noLane {
+		l := &d.lanes[b.lane]
+		if l.busy {
+			l.backlog = append(l.backlog, b)
+			d.mu.Unlock()
+			return
+		}
+		l.busy = true
+		d.busyLanes++
+	}
+	d.ready[b.prio.index()] = append(d.ready[b.prio.index()], b)
+	d.nready++
+	d.mu.Unlock()
+
+	d.notify()
+}
+
+// next pops the most urgent ready batch if acquire grants it a request slot.
+func (d *dispatcher) next(acquire func(Priority) bool) *batch {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := numPriorities - 1; i >= 0; i-- {
+		ready := d.ready[i]
+		if len(ready) == 0 {
+			continue
+		}
+		// lower classes have even fewer slots, no need to look further
+		if !acquire(Priority(i) + PriorityLow) {
+			return nil
+		}
+
+		b := ready[0]
+		ready[0] = nil
+		d.ready[i] = ready[1:]
+		d.nready--
+		if len(ready) == d.maxReady {
+			d.makeSpace()
+		}
+		return b
+	}
+	return nil
+}
+
+// settle is called once the batch in flight of a lane is done with, making
+// the next batch of the lane ready.
+func (d *dispatcher) settle(laneID int) {
+	d.mu.Lock()
+	l := &d.lanes[laneID]
+	if len(l.backlog) == 0 {
+		l.busy = false
+		d.busyLanes--
+		d.mu.Unlock()
+		// the sending loop may be waiting for the last lane to settle
+		d.notify()
+		return
+	}
+
+	b := l.backlog[0]
+	l.backlog[0] = nil
+	l.backlog = l.backlog[1:]
+	if len(l.backlog) == laneBacklog-1 {
+		d.makeSpace()
+	}
+	d.ready[b.prio.index()] = append(d.ready[b.prio.index()], b)
+	d.nready++
+	d.mu.Unlock()
+
+	d.notify()
+}
+
+func (d *dispatcher) setMaxReady(maxReady int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	grown := false
+	for _, ready := range d.ready {
+		if len(ready) >= d.maxReady && len(ready) < maxReady {
+			grown = true
+		}
+	}
+	d.maxReady = maxReady
+	if grown {
+		d.makeSpace()
+	}
+}
+
+// room tells which priority classes have fewer than maxReady batches ready,
+// the batching loop only takes the messages of those.
+func (d *dispatcher) room() (room [numPriorities]bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, ready := range d.ready {
+		room[i] = len(ready) < d.maxReady
+	}
+	return room
+}
+
+// laneFull tells whether the backlog of a lane is full, the batching loop
+// then holds the messages of the lane.
+func (d *dispatcher) laneFull(laneID int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.lanes[laneID].backlog) >= laneBacklog
+}
+
+// idle tells whether no batch is ready, waiting in a backlog or in flight on
+// a lane.
+func (d *dispatcher) idle() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.nready == 0 && d.busyLanes == 0
+}
+
+// makeSpace wakes up the batching loop. Caller must hold mu.
+func (d *dispatcher) makeSpace() {
+	close(d.spaceCh)
+	d.spaceCh = make(chan struct{})
+}
+
+func (d *dispatcher) space() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.spaceCh
+}
+
+func (d *dispatcher) notify() {
+	select {
+	case d.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (d *dispatcher) wake() <-chan struct{} {
+	return d.wakeCh
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventKind is the type of an Event.
+type EventKind int
+
+const (
+	EventBatchSealed    EventKind = iota // a batch was sealed for sending, see Reason
+	EventAttempt                         // a request was made for a batch
+	EventRetryScheduled                  // a batch will be retried after Backoff
+	EventBatchDelivered                  // a batch was delivered
+	EventBatchDropped                    // a batch failed permanently, expired, was shed or left undelivered by Close, see Err
+	EventQueueWatermark                  // the queue of a shard crossed QueueHighWatermark, see Above
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventBatchSealed:
+		return "batch_sealed"
+	case EventAttempt:
+		return "attempt"
+	case EventRetryScheduled:
+		return "retry_scheduled"
+	case EventBatchDelivered:
+		return "batch_delivered"
+	case EventBatchDropped:
+		return "batch_dropped"
+	case EventQueueWatermark:
+		return "queue_watermark"
+	default:
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+}
+
+// SealReason tells why a batch was sealed.
+type SealReason int
+
+const (
+	SealSize     SealReason = iota // number of messages reached the limit
+	SealTime                       // batch live duration reached the limit
+	SealFlush                      // Flush was called
+	SealClose                      // the client is closing
+	SealReconfig                   // batch limits were lowered by Reconfigure
+)
+
+func (r SealReason) String() string {
+	switch r {
+	case SealSize:
+		return "number of message reach limit"
+	case SealTime:
+		return "batch live duration reach limit"
+	case SealFlush:
+		return "flush requested"
+	case SealClose:
+		return "client is closing"
+	case SealReconfig:
+		return "batch limits changed"
+	default:
+		return fmt.Sprintf("SealReason(%d)", int(r))
+	}
+}
+
+// Event is a state change of a BufferedClient. Fields not relevant to the
+// Kind are left zero.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+
+	BatchId  string
+	Messages int
+	Priority Priority
+
+	Reason     SealReason    // EventBatchSealed
+	Attempt    int           // EventAttempt and EventRetryScheduled, 1 for the first request
+	StatusCode int           // EventAttempt, 0 when there was no response
+	Latency    time.Duration // EventAttempt
+	Backoff    time.Duration // EventRetryScheduled
+	Err        error         // EventAttempt, EventRetryScheduled and EventBatchDropped
+
+	Shard      int  // EventQueueWatermark
+	QueueDepth int  // EventQueueWatermark
+	Above      bool // EventQueueWatermark, false once the queue is back below half the watermark
+}
+
+// eventHub fans events out to subscribers without ever blocking the client.
+type eventHub struct {
+	n int32 // subscribers, checked before building events
+
+	mu     sync.RWMutex
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan Event]struct{})}
+}
+
+func (h *eventHub) active() bool {
+	return atomic.LoadInt32(&h.n) > 0
+}
+
+func (h *eventHub) subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subs[ch] = struct{}{}
+	atomic.AddInt32(&h.n, 1)
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			atomic.AddInt32(&h.n, -1)
+			close(ch)
+		}
+	}
+}
+
+// emit delivers e to every subscriber with room for it.
+func (h *eventHub) emit(e Event) {
+	if !h.active() {
+		return
+	}
+	e.Time = time.Now()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+	atomic.StoreInt32(&h.n, 0)
+}
+
+// Subscribe returns a channel receiving the events of the client, with room
+// for buffer events. Events that do not fit are discarded so that a slow
+// subscriber never holds up the client. The channel is closed by cancel or
+// once the client is closed.
+func (bc *BufferedClient) Subscribe(buffer int) (<-chan Event, func()) {
+	return bc.events.subscribe(buffer)
+}
+
+func batchEvent(kind EventKind, b *batch) Event {
+	return Event{Kind: kind, BatchId: b.BatchId, Messages: b.len(), Priority: b.prio}
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	srv := newFakeIngest(t)
+	var requests int64
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		if atomic.AddInt64(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		srv.record(b)
+	})
+
+	bc, err := NewBufferedClient(testBufferedConfig(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, _ := bc.Subscribe(100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := bc.SendAndWait(ctx, newTestMessage(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var kinds []EventKind
+	for e := range events {
+		kinds = append(kinds, e.Kind)
+		if e.Kind == EventBatchSealed && e.Reason != SealTime {
+			t.Fatalf("expected the batch to be sealed by time, got %s", e.Reason)
+		}
+		if e.Kind == EventAttempt && e.Attempt == 1 && e.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("expected the first attempt to fail with 503, got %d", e.StatusCode)
+		}
+	}
+	want := []EventKind{EventBatchSealed, EventAttempt, EventRetryScheduled, EventAttempt, EventBatchDelivered}
+	if len(kinds) != len(want) {
+		t.Fatalf("expected %v, got %v", want, kinds)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, kinds)
+		}
+	}
+}
+
+func TestQueueWatermarkEvent(t *testing.T) {
+	srv := newFakeIngest(t)
+	conf := testBufferedConfig(srv.URL)
+	conf.MaxQueueMessages = 10
+	conf.MaxMessagesPerBatch = 100
+	conf.MaxDurationPerBatch = time.Hour
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close(context.Background())
+	events, cancelSub := bc.Subscribe(10)
+	defer cancelSub()
+
+	// a shard of the client that no batching loop drains
+	q := bc.newShard(0).queue
+	for i := 0; i < 8; i++ {
+		q.push(context.Background(), &envelope{msg: newTestMessage(i)}, true)
+	}
+	takeReleased(q, 5)
+
+	for _, want := range []Event{{QueueDepth: 8, Above: true}, {QueueDepth: 3}} {
+		select {
+		case e := <-events:
+			if e.Kind != EventQueueWatermark || e.Above != want.Above || e.QueueDepth != want.QueueDepth {
+				t.Fatalf("unexpected event %+v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected a watermark event")
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"sync"
+)
+
+// SendFuture tracks the delivery of a single message handed to a
+// BufferedClient. It is resolved once the batch carrying the message is
+// acknowledged by ingest or fails permanently.
+type SendFuture struct {
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func newSendFuture() *SendFuture {
+	return &SendFuture{done: make(chan struct{})}
+}
+
+// Done returns a channel that is closed when the message is delivered or has
+// permanently failed.
+func (f *SendFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Err returns nil while the future is pending or when the message was
+// delivered, otherwise the reason why it was not.
+func (f *SendFuture) Err() error {
+	select {
+	case <-f.done:
+		return f.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the future is resolved or ctx is done.
+func (f *SendFuture) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *SendFuture) resolve(err error) {
+	f.once.Do(func() {
+		f.err = err
+		close(f.done)
+	})
+}
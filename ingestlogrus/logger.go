@@ -0,0 +1,64 @@
+// Package ingestlogrus adapts a logrus logger to the Logger of the ingest
+// client. It is a separate module so that the client does not depend on
+// logrus.
+//
+//	conf.Logger = ingestlogrus.New(logrus.StandardLogger())
+package ingestlogrus
+
+import (
+	client "github.com/funny/ingest-client-go-sdk/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// New returns a client.Logger writing to l, a *logrus.Logger or
+// *logrus.Entry. WithField fields become logrus fields and LevelTrace maps
+// to logrus.TraceLevel. It implements client.LoggerV2.
+func New(l logrus.Ext1FieldLogger) client.Logger {
+	return logger{l}
+}
+
+type logger struct {
+	l logrus.Ext1FieldLogger
+}
+
+func (l logger) WithField(name string, value interface{}) client.Logger {
+	return logger{l.l.WithField(name, value)}
+}
+
+func (l logger) WithFields(fields map[string]interface{}) client.Logger {
+	return logger{l.l.WithFields(fields)}
+}
+
+func (l logger) Enabled(lvl client.LogLevel) bool {
+	var base *logrus.Logger
+	switch l := l.l.(type) {
+	case *logrus.Logger:
+		base = l
+	case *logrus.Entry:
+		base = l.Logger
+	default:
+		return true
+	}
+	return base.IsLevelEnabled(levelOf(lvl))
+}
+
+func levelOf(lvl client.LogLevel) logrus.Level {
+	switch {
+	case lvl >= client.LevelTrace:
+		return logrus.TraceLevel
+	case lvl >= client.LevelDebug:
+		return logrus.DebugLevel
+	case lvl >= client.LevelInfo:
+		return logrus.InfoLevel
+	case lvl >= client.LevelWarn:
+		return logrus.WarnLevel
+	default:
+		return logrus.ErrorLevel
+	}
+}
+
+func (l logger) Trace(msg string) { l.l.Trace(msg) }
+func (l logger) Debug(msg string) { l.l.Debug(msg) }
+func (l logger) Info(msg string)  { l.l.Info(msg) }
+func (l logger) Warn(msg string)  { l.l.Warn(msg) }
+func (l logger) Error(msg string) { l.l.Error(msg) }
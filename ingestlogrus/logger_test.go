@@ -0,0 +1,25 @@
+package ingestlogrus
+
+import (
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestLogger(t *testing.T) {
+	l, hook := test.NewNullLogger()
+	l.SetLevel(logrus.TraceLevel)
+	l.SetOutput(io.Discard)
+
+	New(l).WithField("batchId", "b1").WithField("messages", 3).Trace("sending batch")
+
+	e := hook.LastEntry()
+	if e == nil || e.Level != logrus.TraceLevel || e.Message != "sending batch" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if e.Data["batchId"] != "b1" || e.Data["messages"] != 3 {
+		t.Fatalf("unexpected fields %v", e.Data)
+	}
+}
@@ -0,0 +1,82 @@
+package ingestotel
+
+import (
+	"context"
+
+	client "github.com/funny/ingest-client-go-sdk/v2"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// StatsSource is implemented by *client.Client and *client.BufferedClient.
+type StatsSource interface {
+	Stats() client.Stats
+}
+
+// RegisterMetrics exports the counters and gauges of src as asynchronous
+// instruments, read from src.Stats on every collection. Call Unregister on
+// the returned registration once the client is closed.
+func RegisterMetrics(src StatsSource, opts Options) (metric.Registration, error) {
+	opts.defaults()
+	meter := opts.MeterProvider.Meter(instrumentationName)
+
+	var err error
+	counter := func(name, unit, desc string) metric.Int64ObservableCounter {
+		c, cerr := meter.Int64ObservableCounter(name, metric.WithUnit(unit), metric.WithDescription(desc))
+		if err == nil {
+			err = cerr
+		}
+		return c
+	}
+	gauge := func(name, unit, desc string) metric.Int64ObservableGauge {
+		g, gerr := meter.Int64ObservableGauge(name, metric.WithUnit(unit), metric.WithDescription(desc))
+		if err == nil {
+			err = gerr
+		}
+		return g
+	}
+
+	requests := counter("ingest.client.requests", "{request}", "Requests made to ingest by HTTP status code, 0 when no response was received.")
+	retries := counter("ingest.client.retries", "{request}", "Requests retrying a batch.")
+	messagesSent := counter("ingest.client.messages.sent", "{message}", "Messages delivered.")
+	messagesFailed := counter("ingest.client.messages.failed", "{message}", "Messages that failed permanently.")
+	messagesDropped := counter("ingest.client.messages.dropped", "{message}", "Messages expired or shed before being sent.")
+	batchesSent := counter("ingest.client.batches.sent", "{batch}", "Batches delivered.")
+	batchesFailed := counter("ingest.client.batches.failed", "{batch}", "Batches that failed permanently.")
+	bytesRaw := counter("ingest.client.encoded_bytes", "By", "Bytes of encoded batches before compression.")
+	bytesCompressed := counter("ingest.client.request_bytes", "By", "Bytes of request bodies.")
+
+	inflight := gauge("ingest.client.batches.in_flight", "{batch}", "Requests waiting for a response.")
+	queued := gauge("ingest.client.queue.depth", "{message}", "Messages waiting to be batched.")
+	pending := gauge("ingest.client.batches.pending", "{batch}", "Sealed batches not resolved yet.")
+	retrying := gauge("ingest.client.batches.retrying", "{batch}", "Batches waiting for their next attempt.")
+	buffered := gauge("ingest.client.buffered_bytes", "By", "Encoded bytes held by the client.")
+	limit := gauge("ingest.client.concurrency.limit", "{request}", "Requests allowed in flight.")
+	if err != nil {
+		return nil, err
+	}
+
+	return meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		s := src.Stats()
+
+		for code, n := range s.RequestsByStatus {
+			o.ObserveInt64(requests, int64(n), metric.WithAttributes(StatusCodeKey.Int(code)))
+		}
+		o.ObserveInt64(retries, int64(s.Retries))
+		o.ObserveInt64(messagesSent, int64(s.MessagesSent))
+		o.ObserveInt64(messagesFailed, int64(s.MessagesFailed))
+		o.ObserveInt64(messagesDropped, int64(s.MessagesDropped))
+		o.ObserveInt64(batchesSent, int64(s.BatchesSent))
+		o.ObserveInt64(batchesFailed, int64(s.BatchesFailed))
+		o.ObserveInt64(bytesRaw, int64(s.BytesRaw))
+		o.ObserveInt64(bytesCompressed, int64(s.BytesCompressed))
+
+		o.ObserveInt64(inflight, int64(s.BatchesInFlight))
+		o.ObserveInt64(queued, int64(s.QueuedMessages))
+		o.ObserveInt64(pending, int64(s.PendingBatches))
+		o.ObserveInt64(retrying, int64(s.RetryingBatches))
+		o.ObserveInt64(buffered, s.BufferedBytes)
+		o.ObserveInt64(limit, int64(s.ConcurrencyLimit))
+		return nil
+	}, requests, retries, messagesSent, messagesFailed, messagesDropped, batchesSent, batchesFailed, bytesRaw, bytesCompressed,
+		inflight, queued, pending, retrying, buffered, limit)
+}
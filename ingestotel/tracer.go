@@ -0,0 +1,137 @@
+// Package ingestotel instruments ingest clients with OpenTelemetry. It is a
+// separate module so that the client does not depend on OpenTelemetry.
+//
+//	tracer, _ := ingestotel.NewTracer(ingestotel.Options{})
+//	bc, _ := client.NewBufferedClient(client.BufferedClientConfig{
+//		// ...
+//		Tracer: tracer,
+//	})
+//	reg, _ := ingestotel.RegisterMetrics(bc, ingestotel.Options{})
+//	defer reg.Unregister()
+package ingestotel
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	client "github.com/funny/ingest-client-go-sdk/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/funny/ingest-client-go-sdk/v2/ingestotel"
+
+// Options selects the OpenTelemetry providers, the global ones by default.
+type Options struct {
+	TracerProvider trace.TracerProvider
+	MeterProvider  metric.MeterProvider
+	Propagator     propagation.TextMapPropagator // default is W3C trace context
+}
+
+func (o *Options) defaults() {
+	if o.TracerProvider == nil {
+		o.TracerProvider = otel.GetTracerProvider()
+	}
+	if o.MeterProvider == nil {
+		o.MeterProvider = otel.GetMeterProvider()
+	}
+	if o.Propagator == nil {
+		o.Propagator = propagation.TraceContext{}
+	}
+}
+
+// Attribute keys of spans and metrics.
+const (
+	BatchIDKey         = attribute.Key("ingest.batch_id")
+	MessageCountKey    = attribute.Key("ingest.message_count")
+	EncodedBytesKey    = attribute.Key("ingest.encoded_bytes")
+	CompressedBytesKey = attribute.Key("ingest.compressed_bytes")
+	AttemptKey         = attribute.Key("ingest.attempt")
+	EndpointKey        = attribute.Key("ingest.endpoint")
+	StatusCodeKey      = attribute.Key("http.response.status_code")
+)
+
+type tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+
+	duration  metric.Float64Histogram
+	batchSize metric.Int64Histogram
+}
+
+// NewTracer returns a client.Tracer making a span for every batch and a child
+// span for every request, which carries the W3C traceparent header. It also
+// records the request duration and batch size histograms.
+func NewTracer(opts Options) (client.Tracer, error) {
+	opts.defaults()
+	meter := opts.MeterProvider.Meter(instrumentationName)
+
+	duration, err := meter.Float64Histogram("ingest.client.request.duration",
+		metric.WithDescription("Latency of requests to ingest."),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	batchSize, err := meter.Int64Histogram("ingest.client.batch.size",
+		metric.WithDescription("Messages per batch."),
+		metric.WithUnit("{message}"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &tracer{
+		tracer:     opts.TracerProvider.Tracer(instrumentationName),
+		propagator: opts.Propagator,
+		duration:   duration,
+		batchSize:  batchSize,
+	}, nil
+}
+
+func (t *tracer) StartBatch(ctx context.Context, batch client.BatchInfo) (context.Context, func(error)) {
+	t.batchSize.Record(ctx, int64(batch.Messages))
+
+	ctx, span := t.tracer.Start(ctx, "ingest.collect",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			BatchIDKey.String(batch.BatchId),
+			MessageCountKey.Int(batch.Messages),
+			EncodedBytesKey.Int(batch.EncodedBytes),
+			CompressedBytesKey.Int(batch.CompressedBytes),
+		))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func (t *tracer) StartAttempt(ctx context.Context, attempt client.AttemptInfo, header http.Header) (context.Context, func(int, error)) {
+	ctx, span := t.tracer.Start(ctx, "ingest.request",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			BatchIDKey.String(attempt.BatchId),
+			AttemptKey.Int(attempt.Attempt),
+			EndpointKey.String(attempt.Endpoint),
+		))
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(header))
+
+	start := time.Now()
+	return ctx, func(statusCode int, err error) {
+		t.duration.Record(ctx, time.Since(start).Seconds(),
+			metric.WithAttributes(EndpointKey.String(attempt.Endpoint), StatusCodeKey.Int(statusCode)))
+
+		span.SetAttributes(StatusCodeKey.Int(statusCode))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
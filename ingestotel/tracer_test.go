@@ -0,0 +1,111 @@
+package ingestotel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	client "github.com/funny/ingest-client-go-sdk/v2"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	var requests int64
+	var traceparent atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent.Store(r.Header.Get("traceparent"))
+		if atomic.AddInt64(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	opts := Options{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		MeterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	}
+	tracer, err := NewTracer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.NewClient(client.Config{Endpoint: srv.URL, Tracer: tracer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := RegisterMetrics(c, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Unregister()
+
+	msgs := &client.Messages{BatchId: "b-1", Messages: []client.Message{{Type: "Event", Data: map[string]interface{}{"#event": "login"}}}}
+	if err := c.Collect(context.Background(), msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 3 {
+		t.Fatalf("expected 2 request spans and 1 batch span, got %d", len(ended))
+	}
+	batch := ended[2]
+	if batch.Name() != "ingest.collect" || !hasAttr(batch.Attributes(), BatchIDKey.String("b-1")) || !hasAttr(batch.Attributes(), MessageCountKey.Int(1)) {
+		t.Fatalf("unexpected batch span %s %v", batch.Name(), batch.Attributes())
+	}
+	for i, span := range ended[:2] {
+		if span.Parent().SpanID() != batch.SpanContext().SpanID() {
+			t.Fatal("expected request spans to be children of the batch span")
+		}
+		if !hasAttr(span.Attributes(), AttemptKey.Int(i+1)) {
+			t.Fatalf("unexpected attempt attributes %v", span.Attributes())
+		}
+	}
+	if !hasAttr(ended[0].Attributes(), StatusCodeKey.Int(http.StatusServiceUnavailable)) {
+		t.Fatalf("expected the status code of the failed attempt, got %v", ended[0].Attributes())
+	}
+	if tp, _ := traceparent.Load().(string); tp == "" || tp[3:35] != batch.SpanContext().TraceID().String() {
+		t.Fatalf("expected traceparent of the batch trace, got %q", tp)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = true
+			if m.Name != "ingest.client.requests" {
+				continue
+			}
+			// same attribute type as on the spans, so that both can be joined
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				if v, ok := dp.Attributes.Value(StatusCodeKey); !ok || v.Type() != attribute.INT64 {
+					t.Errorf("expected an int status code, got %v", dp.Attributes)
+				}
+			}
+		}
+	}
+	for _, name := range []string{"ingest.client.request.duration", "ingest.client.batch.size", "ingest.client.requests", "ingest.client.messages.sent", "ingest.client.queue.depth"} {
+		if !got[name] {
+			t.Errorf("missing metric %s", name)
+		}
+	}
+}
+
+func hasAttr(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, kv := range attrs {
+		if kv == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+// Package ingestprom exports the metrics of an ingest client to Prometheus. It
+// is a separate module so that the client does not depend on Prometheus.
+//
+//	bc, _ := client.NewBufferedClient(conf)
+//	prometheus.MustRegister(ingestprom.NewCollector(bc, ingestprom.Options{
+//		ConstLabels: prometheus.Labels{"client_id": conf.ClientId},
+//	}))
+package ingestprom
+
+import (
+	"strconv"
+
+	client "github.com/funny/ingest-client-go-sdk/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatsSource is implemented by *client.Client and *client.BufferedClient.
+type StatsSource interface {
+	Stats() client.Stats
+}
+
+// Options customizes the metric names and labels.
+type Options struct {
+	Namespace   string            // prefix of the metric names, default is ingest_client
+	ConstLabels prometheus.Labels // labels added to every metric, such as the client id
+}
+
+type collector struct {
+	src StatsSource
+
+	requests        *prometheus.Desc
+	retries         *prometheus.Desc
+	messagesSent    *prometheus.Desc
+	messagesFailed  *prometheus.Desc
+	messagesDropped *prometheus.Desc
+	batchesSent     *prometheus.Desc
+	batchesFailed   *prometheus.Desc
+	bytesRaw        *prometheus.Desc
+	bytesCompressed *prometheus.Desc
+	latency         *prometheus.Desc
+	batchSize       *prometheus.Desc
+
+	inflight         *prometheus.Desc
+	queued           *prometheus.Desc
+	pending          *prometheus.Desc
+	retrying         *prometheus.Desc
+	bufferedBytes    *prometheus.Desc
+	concurrencyLimit *prometheus.Desc
+	lastError        *prometheus.Desc
+}
+
+// NewCollector returns a collector reading the stats of src on every scrape.
+func NewCollector(src StatsSource, opts Options) prometheus.Collector {
+	if opts.Namespace == "" {
+		opts.Namespace = "ingest_client"
+	}
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(opts.Namespace, "", name), help, labels, opts.ConstLabels)
+	}
+
+	return &collector{
+		src: src,
+
+		requests:        desc("requests_total", "Requests made to ingest by HTTP status code, 0 when no response was received.", "code"),
+		retries:         desc("retries_total", "Requests retrying a batch."),
+		messagesSent:    desc("messages_sent_total", "Messages delivered."),
+		messagesFailed:  desc("messages_failed_total", "Messages that failed permanently."),
+		messagesDropped: desc("messages_dropped_total", "Messages expired or shed before being sent."),
+		batchesSent:     desc("batches_sent_total", "Batches delivered."),
+		batchesFailed:   desc("batches_failed_total", "Batches that failed permanently."),
+		bytesRaw:        desc("encoded_bytes_total", "Bytes of encoded batches before compression."),
+		bytesCompressed: desc("request_bytes_total", "Bytes of request bodies."),
+		latency:         desc("request_duration_seconds", "Latency of requests by endpoint.", "endpoint"),
+		batchSize:       desc("batch_size_messages", "Messages per batch."),
+
+		inflight:         desc("batches_in_flight", "Requests waiting for a response."),
+		queued:           desc("queue_depth_messages", "Messages waiting to be batched."),
+		pending:          desc("pending_batches", "Sealed batches not resolved yet."),
+		retrying:         desc("retrying_batches", "Batches waiting for their next attempt."),
+		bufferedBytes:    desc("buffered_bytes", "Encoded bytes held by the client."),
+		concurrencyLimit: desc("concurrency_limit", "Requests allowed in flight."),
+		lastError:        desc("last_error_timestamp_seconds", "Time of the last failed request."),
+	}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.requests, c.retries, c.messagesSent, c.messagesFailed, c.messagesDropped,
+		c.batchesSent, c.batchesFailed, c.bytesRaw, c.bytesCompressed, c.latency, c.batchSize,
+		c.inflight, c.queued, c.pending, c.retrying, c.bufferedBytes, c.concurrencyLimit, c.lastError,
+	} {
+		ch <- d
+	}
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.src.Stats()
+
+	counter := func(d *prometheus.Desc, v uint64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+	}
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+	}
+
+	for code, n := range s.RequestsByStatus {
+		counter(c.requests, n, strconv.Itoa(code))
+	}
+	counter(c.retries, s.Retries)
+	counter(c.messagesSent, s.MessagesSent)
+	counter(c.messagesFailed, s.MessagesFailed)
+	counter(c.messagesDropped, s.MessagesDropped)
+	counter(c.batchesSent, s.BatchesSent)
+	counter(c.batchesFailed, s.BatchesFailed)
+	counter(c.bytesRaw, s.BytesRaw)
+	counter(c.bytesCompressed, s.BytesCompressed)
+
+	for endpoint, h := range s.Latency {
+		buckets := make(map[float64]uint64, len(h.Bounds))
+		var cumulative uint64
+		for i, bound := range h.Bounds {
+			cumulative += h.Counts[i]
+			buckets[bound.Seconds()] = cumulative
+		}
+		ch <- prometheus.MustNewConstHistogram(c.latency, h.Count, h.Sum.Seconds(), buckets, endpoint)
+	}
+
+	buckets := make(map[float64]uint64, len(s.BatchSizes.Bounds))
+	var cumulative uint64
+	for i, bound := range s.BatchSizes.Bounds {
+		cumulative += s.BatchSizes.Counts[i]
+		buckets[float64(bound)] = cumulative
+	}
+	ch <- prometheus.MustNewConstHistogram(c.batchSize, s.BatchSizes.Count, float64(s.BatchSizes.Sum), buckets)
+
+	gauge(c.inflight, float64(s.BatchesInFlight))
+	gauge(c.queued, float64(s.QueuedMessages))
+	gauge(c.pending, float64(s.PendingBatches))
+	gauge(c.retrying, float64(s.RetryingBatches))
+	gauge(c.bufferedBytes, float64(s.BufferedBytes))
+	gauge(c.concurrencyLimit, float64(s.ConcurrencyLimit))
+	if !s.LastErrorTime.IsZero() {
+		gauge(c.lastError, float64(s.LastErrorTime.UnixNano())/1e9)
+	}
+}
@@ -0,0 +1,70 @@
+package ingestprom
+
+import (
+	"testing"
+	"time"
+
+	client "github.com/funny/ingest-client-go-sdk/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type fakeSource client.Stats
+
+func (f fakeSource) Stats() client.Stats { return client.Stats(f) }
+
+func TestCollector(t *testing.T) {
+	src := fakeSource{
+		MessagesSent:     10,
+		Retries:          2,
+		RequestsByStatus: map[int]uint64{200: 3, 503: 2},
+		QueuedMessages:   7,
+		Latency: map[string]client.LatencyHistogram{
+			"http://ingest": {Bounds: []time.Duration{time.Second}, Counts: []uint64{4, 1}, Count: 5, Sum: 3 * time.Second},
+		},
+		BatchSizes: client.BatchSizeHistogram{Bounds: []int{10}, Counts: []uint64{3, 0}, Count: 3, Sum: 10},
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewCollector(src, Options{ConstLabels: prometheus.Labels{"client_id": "game-1"}}))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]float64{}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			// labels are sorted, client_id comes before code and endpoint
+			if m.GetLabel()[0].GetName() != "client_id" {
+				t.Fatalf("missing const label on %s", mf.GetName())
+			}
+			switch {
+			case m.Counter != nil:
+				got[mf.GetName()] += m.Counter.GetValue()
+			case m.Gauge != nil:
+				got[mf.GetName()] = m.Gauge.GetValue()
+			case m.Histogram != nil:
+				got[mf.GetName()] = float64(m.Histogram.GetSampleCount())
+			}
+		}
+	}
+
+	want := map[string]float64{
+		"ingest_client_messages_sent_total":      10,
+		"ingest_client_retries_total":            2,
+		"ingest_client_requests_total":           5,
+		"ingest_client_queue_depth_messages":     7,
+		"ingest_client_request_duration_seconds": 5,
+		"ingest_client_batch_size_messages":      3,
+		"ingest_client_messages_dropped_total":   0,
+		"ingest_client_concurrency_limit":        0,
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("%s = %v, want %v", name, got[name], v)
+		}
+	}
+	if _, ok := got["ingest_client_last_error_timestamp_seconds"]; ok {
+		t.Error("expected no last error timestamp without errors")
+	}
+}
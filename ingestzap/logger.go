@@ -0,0 +1,65 @@
+// Package ingestzap adapts a zap logger to the Logger of the ingest client. It
+// is a separate module so that the client does not depend on zap.
+//
+//	conf.Logger = ingestzap.New(zapLogger)
+package ingestzap
+
+import (
+	"sort"
+
+	client "github.com/funny/ingest-client-go-sdk/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New returns a client.Logger writing to l, WithField fields become zap
+// fields. zap has no trace level, Trace logs at debug level with a trace
+// field set to true. It implements client.LoggerV2.
+func New(l *zap.Logger) client.Logger {
+	return logger{l}
+}
+
+type logger struct {
+	l *zap.Logger
+}
+
+func (l logger) WithField(name string, value interface{}) client.Logger {
+	return logger{l.l.With(zap.Any(name, value))}
+}
+
+func (l logger) WithFields(fields map[string]interface{}) client.Logger {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	zfields := make([]zap.Field, 0, len(fields))
+	for _, name := range names {
+		zfields = append(zfields, zap.Any(name, fields[name]))
+	}
+	return logger{l.l.With(zfields...)}
+}
+
+func (l logger) Enabled(lvl client.LogLevel) bool {
+	return l.l.Core().Enabled(levelOf(lvl))
+}
+
+func levelOf(lvl client.LogLevel) zapcore.Level {
+	switch {
+	case lvl >= client.LevelDebug:
+		return zapcore.DebugLevel
+	case lvl >= client.LevelInfo:
+		return zapcore.InfoLevel
+	case lvl >= client.LevelWarn:
+		return zapcore.WarnLevel
+	default:
+		return zapcore.ErrorLevel
+	}
+}
+
+func (l logger) Trace(msg string) { l.l.Debug(msg, zap.Bool("trace", true)) }
+func (l logger) Debug(msg string) { l.l.Debug(msg) }
+func (l logger) Info(msg string)  { l.l.Info(msg) }
+func (l logger) Warn(msg string)  { l.l.Warn(msg) }
+func (l logger) Error(msg string) { l.l.Error(msg) }
@@ -0,0 +1,31 @@
+package ingestzap
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := New(zap.New(core)).WithField("batchId", "b1")
+	l.WithField("messages", 3).Warn("failed to send request")
+	l.Trace("sending")
+
+	entries := logs.AllUntimed()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Level != zapcore.WarnLevel || e.Message != "failed to send request" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if m := e.ContextMap(); m["batchId"] != "b1" || m["messages"] != int64(3) {
+		t.Fatalf("unexpected fields %v", m)
+	}
+	if e := entries[1]; e.Level != zapcore.DebugLevel || e.ContextMap()["trace"] != true {
+		t.Fatalf("unexpected trace entry %+v", e)
+	}
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// latency is considered healthy up to this multiple of the lowest one seen
+	limiterLatencyTolerance = 2
+	// the lowest latency is forgotten this often to follow changes of the network
+	limiterBaselineWindow = 30 * time.Second
+	limiterBackoffRatio   = 0.5
+)
+
+// concurrencyLimiter bounds the number of requests in flight. When adaptive,
+// the limit follows an additive-increase/multiplicative-decrease scheme: it
+// grows by about one every limit requests that succeed with healthy latency,
+// and is halved, at most once per round trip, when ingest shows signs of
+// overload (429, 5xx, timeouts or latency beyond twice the lowest seen).
+type concurrencyLimiter struct {
+	adaptive bool
+	min, max float64
+
+	mu           sync.Mutex
+	limit        float64
+	inflight     int
+	releasedCh   chan struct{} // closed and replaced when a slot may be available
+	baseline     time.Duration // lowest latency seen in the current window
+	baselineAt   time.Time
+	lastDecrease time.Time
+}
+
+func newConcurrencyLimiter(adaptive bool, min, max int) *concurrencyLimiter {
+	l := &concurrencyLimiter{
+		adaptive:   adaptive,
+		min:        float64(min),
+		max:        float64(max),
+		limit:      float64(max),
+		releasedCh: make(chan struct{}),
+	}
+	if adaptive {
+		l.limit = l.min
+	}
+	return l
+}
+
+// tryAcquire takes a free slot if one is left beyond the reserved ones. At
+// least one slot is always usable, so that reservations never starve a class
+// once the adaptive limit has shrunk below them.
+func (l *concurrencyLimiter) tryAcquire(reserved int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit := int(l.limit) - reserved
+	if limit < 1 {
+		limit = 1
+	}
+	if l.inflight >= limit {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// released returns a channel closed once a slot may be available.
+func (l *concurrencyLimiter) released() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.releasedCh
+}
+
+func (l *concurrencyLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight--
+	l.wakeUp()
+}
+
+// observe adjusts the limit after a request that took latency and ended with
+// err.
+func (l *concurrencyLimiter) observe(latency time.Duration, err error) {
+	if !l.adaptive {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.baseline == 0 || latency < l.baseline || now.Sub(l.baselineAt) > limiterBaselineWindow {
+		l.baseline = latency
+		l.baselineAt = now
+	}
+
+	switch {
+	case err == nil && latency <= l.baseline*limiterLatencyTolerance:
+		l.limit = math.Min(l.max, l.limit+1/l.limit)
+		l.wakeUp()
+	case err == nil, isOverload(err):
+		// a batch of requests sent at the same time fails together, only
+		// count them as one congestion signal
+		if now.Sub(l.lastDecrease) < latency {
+			return
+		}
+		l.lastDecrease = now
+		l.limit = math.Max(l.min, math.Floor(l.limit*limiterBackoffRatio))
+	}
+}
+
+// setMax changes the upper bound of the limit, the limit itself when not
+// adaptive.
+func (l *concurrencyLimiter) setMax(max int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.max = float64(max)
+	if !l.adaptive || l.limit > l.max {
+		l.limit = l.max
+	}
+	l.wakeUp()
+}
+
+// current returns the current limit.
+func (l *concurrencyLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// wakeUp lets waiters check for a slot again. Caller must hold mu.
+func (l *concurrencyLimiter) wakeUp() {
+	close(l.releasedCh)
+	l.releasedCh = make(chan struct{})
+}
+
+// isOverload tells whether err means ingest is overloaded.
+func isOverload(err error) bool {
+	var ierr Error
+	if errors.As(err, &ierr) {
+		return ierr.StatusCode == http.StatusTooManyRequests || ierr.StatusCode >= 500
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ETIMEDOUT) {
+		return true
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiterAIMD(t *testing.T) {
+	l := newConcurrencyLimiter(true, 2, 16)
+	if l.current() != 2 {
+		t.Fatalf("expected to start from the min limit, got %d", l.current())
+	}
+
+	for i := 0; i < 500; i++ {
+		l.observe(10*time.Millisecond, nil)
+	}
+	if l.current() != 16 {
+		t.Fatalf("expected healthy requests to grow the limit to max, got %d", l.current())
+	}
+
+	overload := Error{StatusCode: http.StatusServiceUnavailable}
+	l.observe(time.Second, overload)
+	l.observe(time.Second, overload) // same congestion event
+	if l.current() != 8 {
+		t.Fatalf("expected the limit to be halved once, got %d", l.current())
+	}
+
+	l.observe(10*time.Millisecond, Error{StatusCode: http.StatusBadRequest})
+	if l.current() != 8 {
+		t.Fatalf("expected client errors to leave the limit alone, got %d", l.current())
+	}
+
+	for i := 0; i < 10; i++ {
+		l.lastDecrease = time.Time{}
+		l.observe(time.Second, context.DeadlineExceeded)
+	}
+	if l.current() != 2 {
+		t.Fatalf("expected the limit to stop at min, got %d", l.current())
+	}
+}
+
+func TestConcurrencyLimiterAcquire(t *testing.T) {
+	l := newConcurrencyLimiter(false, 1, 3)
+	if !l.tryAcquire(0) || !l.tryAcquire(1) {
+		t.Fatal("expected free slots")
+	}
+	if l.tryAcquire(1) {
+		t.Fatal("expected the reserved slot to be kept")
+	}
+
+	released := l.released()
+	l.release()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("release did not signal a free slot")
+	}
+	if !l.tryAcquire(1) || !l.tryAcquire(0) || l.tryAcquire(0) {
+		t.Fatal("unexpected slots after release")
+	}
+}
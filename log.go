@@ -1,10 +1,17 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -19,6 +26,57 @@ var (
 
 type LogLevel int
 
+func (lvl LogLevel) String() string {
+	switch lvl {
+	case LevelTrace:
+		return "TRACE"
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "LOG"
+	}
+}
+
+// ParseLogLevel parses a level name such as "debug" or "WARN", or a number.
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "trace":
+		return LevelTrace, nil
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+		return LogLevel(n), nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// LogLevelFromEnv parses the level in the environment variable key, def is
+// returned when it is not set.
+func LogLevelFromEnv(key string, def LogLevel) (LogLevel, error) {
+	s, ok := os.LookupEnv(key)
+	if !ok || s == "" {
+		return def, nil
+	}
+	lvl, err := ParseLogLevel(s)
+	if err != nil {
+		return def, fmt.Errorf("%s: %w", key, err)
+	}
+	return lvl, nil
+}
+
 type Logger interface {
 	WithField(name string, value interface{}) Logger
 	Trace(msg string)
@@ -28,27 +86,133 @@ type Logger interface {
 	Error(msg string)
 }
 
+// LoggerV2 is implemented by loggers that can tell whether a level is
+// enabled, so that costly fields are only computed when logged, and take
+// several fields at once. The loggers of NewLogger implement it, the SDK
+// checks for it on any Logger.
+type LoggerV2 interface {
+	Logger
+	Enabled(lvl LogLevel) bool
+	WithFields(fields map[string]interface{}) Logger
+}
+
+// logEnabled tells whether l logs at lvl, always true when l does not
+// implement LoggerV2.
+func logEnabled(l Logger, lvl LogLevel) bool {
+	if l2, ok := l.(LoggerV2); ok {
+		return l2.Enabled(lvl)
+	}
+	return true
+}
+
+// LogFormat is the output format of the loggers of NewLogger.
+type LogFormat int
+
+const (
+	// LogFormatText is the standard log prefix followed by the level, the
+	// message and the fields as a JSON object:
+	//
+	//	2006/01/02 15:04:05 WARN failed to send request {"batchId":"..."}
+	LogFormatText LogFormat = iota
+	// LogFormatLogfmt writes key=value pairs:
+	//
+	//	time=2006-01-02T15:04:05.000Z07:00 level=WARN msg="failed to send request" batchId=...
+	LogFormatLogfmt
+	// LogFormatJSON writes a JSON object per line:
+	//
+	//	{"time":"2006-01-02T15:04:05.000Z07:00","level":"WARN","msg":"failed to send request","batchId":"..."}
+	LogFormatJSON
+)
+
+func (f LogFormat) String() string {
+	switch f {
+	case LogFormatText:
+		return "text"
+	case LogFormatLogfmt:
+		return "logfmt"
+	case LogFormatJSON:
+		return "json"
+	default:
+		return fmt.Sprintf("LogFormat(%d)", int(f))
+	}
+}
+
+// ParseLogFormat parses "text", "logfmt" or "json".
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "text", "":
+		return LogFormatText, nil
+	case "logfmt":
+		return LogFormatLogfmt, nil
+	case "json":
+		return LogFormatJSON, nil
+	}
+	return 0, fmt.Errorf("unknown log format %q", s)
+}
+
+// LoggerOption customizes the loggers of NewLogger.
+type LoggerOption func(*logger)
+
+// WithLogFormat selects the output format, default is LogFormatText.
+func WithLogFormat(format LogFormat) LoggerOption {
+	return func(l *logger) {
+		l.format = format
+	}
+}
+
+// field is a node of the fields of a logger, shared by the loggers derived
+// from it so that WithField does not copy them.
+type field struct {
+	name  string
+	value interface{}
+	prev  *field
+}
+
 type logger struct {
 	Writer io.Writer
-	fields []func(map[string]interface{})
+	fields *field // most recent first
 	logger *log.Logger
 	level  LogLevel
+	format LogFormat
 }
 
-func NewLogger(w io.Writer, lvl LogLevel) *logger {
-	return &logger{logger: log.New(w, "", log.LstdFlags), level: lvl}
+func NewLogger(w io.Writer, lvl LogLevel, opts ...LoggerOption) *logger {
+	l := &logger{Writer: w, level: lvl}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.format == LogFormatText {
+		l.logger = log.New(w, "", log.LstdFlags)
+	} else {
+		l.logger = log.New(w, "", 0)
+	}
+	return l
 }
 
-var _ Logger = &logger{}
+var _ LoggerV2 = &logger{}
 
 func (l *logger) WithField(name string, value interface{}) Logger {
 	newL := *l
-	newL.fields = append(newL.fields, func(m map[string]interface{}) {
-		m[name] = value
-	})
+	newL.fields = &field{name: name, value: value, prev: l.fields}
+	return &newL
+}
+
+func (l *logger) WithFields(fields map[string]interface{}) Logger {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	newL := *l
+	for _, name := range names {
+		newL.fields = &field{name: name, value: fields[name], prev: newL.fields}
+	}
 	return &newL
 }
 
+func (l *logger) Enabled(lvl LogLevel) bool { return lvl <= l.level }
+
 func (l *logger) Trace(msg string) { l.log(LevelTrace, msg) }
 func (l *logger) Debug(msg string) { l.log(LevelDebug, msg) }
 func (l *logger) Info(msg string)  { l.log(LevelInfo, msg) }
@@ -60,40 +224,125 @@ func (l *logger) log(lvl LogLevel, msg string) {
 		return
 	}
 
-	m := make(map[string]interface{}, len(l.fields))
-	for _, f := range l.fields {
-		f(m)
+	fields := l.collect()
+	var buf bytes.Buffer
+	switch l.format {
+	case LogFormatLogfmt:
+		buf.WriteString("time=")
+		buf.WriteString(time.Now().Format("2006-01-02T15:04:05.000Z07:00"))
+		buf.WriteString(" level=")
+		buf.WriteString(lvl.String())
+		buf.WriteString(" msg=")
+		writeLogfmtValue(&buf, msg)
+		for _, f := range fields {
+			buf.WriteByte(' ')
+			writeLogfmtKey(&buf, f.name)
+			buf.WriteByte('=')
+			writeLogfmtValue(&buf, f.value)
+		}
+	case LogFormatJSON:
+		buf.WriteString(`{"time":`)
+		writeJSONValue(&buf, time.Now().Format("2006-01-02T15:04:05.000Z07:00"))
+		buf.WriteString(`,"level":`)
+		writeJSONValue(&buf, lvl.String())
+		buf.WriteString(`,"msg":`)
+		writeJSONValue(&buf, msg)
+		for _, f := range fields {
+			buf.WriteByte(',')
+			writeJSONValue(&buf, f.name)
+			buf.WriteByte(':')
+			writeJSONValue(&buf, f.value)
+		}
+		buf.WriteByte('}')
+	default:
+		sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+		buf.WriteString(lvl.String())
+		buf.WriteByte(' ')
+		buf.WriteString(msg)
+		buf.WriteString(" {")
+		for i, f := range fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONValue(&buf, f.name)
+			buf.WriteByte(':')
+			writeJSONValue(&buf, f.value)
+		}
+		buf.WriteByte('}')
+	}
+	l.logger.Println(buf.String())
+}
+
+// collect returns the fields of l in the order they were added, a field set
+// again keeps its first position and takes its last value.
+func (l *logger) collect() []field {
+	var fields []field
+	for f := l.fields; f != nil; f = f.prev {
+		fields = append(fields, *f)
+	}
+	for i, j := 0, len(fields)-1; i < j; i, j = i+1, j-1 {
+		fields[i], fields[j] = fields[j], fields[i]
 	}
 
-	var (
-		b   []byte
-		err error
-	)
-	if len(l.fields) > 0 {
-		b, err = json.Marshal(m)
-		if err != nil {
-			println("Logger error: " + err.Error())
-			return
+	n := 0
+next:
+	for _, f := range fields {
+		for i := 0; i < n; i++ {
+			if fields[i].name == f.name {
+				fields[i].value = f.value
+				continue next
+			}
 		}
-	} else {
-		b = []byte(`{}`)
+		fields[n] = f
+		n++
 	}
+	return fields[:n]
+}
 
-	var lvlStr string
-	switch lvl {
-	case LevelTrace:
-		lvlStr = "TRACE"
-	case LevelDebug:
-		lvlStr = "DEBUG"
-	case LevelInfo:
-		lvlStr = "INFO"
-	case LevelWarn:
-		lvlStr = "WARN"
-	case LevelError:
-		lvlStr = "ERROR"
-	default:
-		lvlStr = "LOG"
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
 	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
 
-	l.logger.Println(lvlStr, msg, string(b))
+func writeLogfmtKey(buf *bytes.Buffer, key string) {
+	for _, r := range key {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError {
+			buf.WriteByte('_')
+		} else {
+			buf.WriteRune(r)
+		}
+	}
+}
+
+func writeLogfmtValue(buf *bytes.Buffer, v interface{}) {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	case nil:
+		s = "null"
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		s = fmt.Sprint(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			s = fmt.Sprint(v)
+		} else {
+			s = string(b)
+		}
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\\\t\r\n") || !utf8.ValidString(s) {
+		s = strconv.Quote(s)
+	}
+	buf.WriteString(s)
 }
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLoggerFormats(t *testing.T) {
+	tests := []struct {
+		format LogFormat
+		want   string
+	}{
+		{LogFormatText, `WARN failed to send {"batchId":"b 1","err":"boom","messages":3}`},
+		{LogFormatLogfmt, `level=WARN msg="failed to send" batchId="b 1" messages=3 err=boom`},
+		{LogFormatJSON, `"level":"WARN","msg":"failed to send","batchId":"b 1","messages":3,"err":"boom"}`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		l := NewLogger(&buf, LevelInfo, WithLogFormat(tt.format))
+		l.WithField("batchId", "b 0").(LoggerV2).
+			WithFields(map[string]interface{}{"messages": 3, "batchId": "b 1"}).
+			WithField("err", errors.New("boom")).
+			Warn("failed to send")
+		l.Debug("not logged")
+
+		out := buf.String()
+		if !strings.Contains(out, tt.want) || strings.Count(out, "\n") != 1 {
+			t.Errorf("%s: expected %s in %q", tt.format, tt.want, out)
+		}
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for s, want := range map[string]LogLevel{"trace": LevelTrace, "DEBUG": LevelDebug, " warning ": LevelWarn, "15": 15} {
+		if lvl, err := ParseLogLevel(s); err != nil || lvl != want {
+			t.Errorf("%q: expected %v, got %v, %v", s, want, lvl, err)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+
+	t.Setenv("INGEST_LOG_LEVEL", "debug")
+	lvl, err := LogLevelFromEnv("INGEST_LOG_LEVEL", LevelWarn)
+	if err != nil || lvl != LevelDebug {
+		t.Fatalf("expected debug, got %v, %v", lvl, err)
+	}
+	if !logEnabled(NewLogger(nil, lvl), LevelDebug) || logEnabled(NewLogger(nil, lvl), LevelTrace) {
+		t.Fatal("unexpected enabled levels")
+	}
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+const (
+	noLane = -1
+
+	// sealed batches a lane holds before the batching loop holds its messages
+	laneBacklog = 4
+)
+
+// PartitionByField returns a PartitionKey reading the field name of
+// map[string]interface{} message data or of TypedClient messages, such as
+// "#user_id".
+func PartitionByField(name string) func(*Message) string {
+	return func(m *Message) string {
+		var value interface{}
+		switch data := m.Data.(type) {
+		case map[string]interface{}:
+			value = data[name]
+		case typedData:
+			value = data.field(name)
+		}
+		switch v := value.(type) {
+		case nil:
+			return ""
+		case string:
+			return v
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+}
+
+// laneOf picks the lane of m among n. Messages without a key carry no
+// ordering constraint and are spread evenly.
+func (bc *BufferedClient) laneOf(s *shard, m *Message, n int) int {
+	if n == 1 {
+		return 0
+	}
+
+	key := bc.conf.PartitionKey(m)
+	if key == "" {
+		s.nextLane = (s.nextLane + 1) % n
+		return s.nextLane
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
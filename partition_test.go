@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPartitionedOrdering(t *testing.T) {
+	srv := newFakeIngest(t)
+	var requests int64
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+		if atomic.AddInt64(&requests, 1)%3 == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		srv.record(b)
+	})
+
+	conf := testBufferedConfig(srv.URL)
+	conf.MaxMessagesPerBatch = 3
+	conf.MaxConcurrency = 4
+	conf.PartitionKey = PartitionByField("#user_id")
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const users, perUser = 5, 40
+	for i := 0; i < perUser; i++ {
+		for u := 0; u < users; u++ {
+			msg := &Message{Type: "Event", Data: map[string]interface{}{"#user_id": u, "seq": i}}
+			if err := bc.Send(ctx, msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := bc.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	last := map[float64]float64{}
+	total := 0
+	for _, b := range srv.batches {
+		for _, m := range b.Messages {
+			data := m.Data.(map[string]interface{})
+			user, seq := data["#user_id"].(float64), data["seq"].(float64)
+			if prev, ok := last[user]; ok && seq != prev+1 {
+				t.Fatalf("user %v: message %v delivered after %v", user, seq, prev)
+			}
+			last[user] = seq
+			total++
+		}
+	}
+	if total != users*perUser {
+		t.Fatalf("expected %d messages, got %d", users*perUser, total)
+	}
+}
+
+func TestPartitionStuckLaneKeepsOthersMoving(t *testing.T) {
+	srv := newFakeIngest(t)
+	gate := make(chan struct{})
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		if b.Messages[0].Data.(map[string]interface{})["#user_id"] == "hot" {
+			<-gate
+		}
+		srv.record(b)
+	})
+
+	conf := testBufferedConfig(srv.URL)
+	conf.MaxMessagesPerBatch = 1
+	conf.MaxConcurrency = 8
+	conf.PartitionKey = PartitionByField("#user_id")
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close(context.Background())
+	defer close(gate)
+
+	lane := func(key string) uint32 {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return h.Sum32() % uint32(conf.MaxConcurrency)
+	}
+	cold := "cold"
+	for i := 0; lane(cold) == lane("hot"); i++ {
+		cold = fmt.Sprintf("cold-%d", i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		if err := bc.Send(ctx, &Message{Type: "Event", Data: map[string]interface{}{"#user_id": "hot", "seq": i}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the hot lane has a request in flight, a full backlog and a full open
+	// batch, its other messages are held
+	for s := bc.Stats(); s.PendingBatches != 1+laneBacklog || s.QueuedMessages != 10-2-laneBacklog; s = bc.Stats() {
+		if ctx.Err() != nil {
+			t.Fatalf("hot lane not backed up: %+v", s)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := bc.SendAndWait(ctx, &Message{Type: "Event", Data: map[string]interface{}{"#user_id": cold}}); err != nil {
+		t.Fatalf("cold key held up by a stuck lane: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+// Priority classes of messages in a BufferedClient. Each class has its own
+// queue and batches; higher classes are batched and sent first, and shed last
+// when the queue overflows.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+
+	numPriorities = 3
+)
+
+// allPriorities selects every class where a set of classes is expected.
+var allPriorities = [numPriorities]bool{true, true, true}
+
+func (p Priority) index() int {
+	return int(p - PriorityLow)
+}
+
+func (p Priority) valid() bool {
+	return p >= PriorityLow && p <= PriorityHigh
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+// PriorityConfig overrides batching and concurrency for a priority class.
+type PriorityConfig struct {
+	MaxMessagesPerBatch int           // default is MaxMessagesPerBatch
+	MaxDurationPerBatch time.Duration // default is MaxDurationPerBatch
+
+	// ReservedConcurrency is the number of request slots that lower classes
+	// may not use, so that this class is never starved by them.
+	ReservedConcurrency int
+}
+
+// priorityClass is the resolved configuration of a priority class.
+type priorityClass struct {
+	maxMessages   int
+	maxDuration   time.Duration
+	reservedAbove int // slots reserved by higher classes
+}
+
+func resolvePriorities(config BufferedClientConfig) ([numPriorities]priorityClass, error) {
+	var classes [numPriorities]priorityClass
+	for p, pc := range config.Priorities {
+		if !p.valid() {
+			return classes, fmt.Errorf("unknown priority %d", int(p))
+		}
+		if pc.ReservedConcurrency < 0 {
+			return classes, fmt.Errorf("negative ReservedConcurrency for %s priority", p)
+		}
+	}
+
+	reserved := 0
+	for i := numPriorities - 1; i >= 0; i-- {
+		pc := config.Priorities[Priority(i)+PriorityLow]
+		classes[i] = priorityClass{
+			maxMessages:   pc.MaxMessagesPerBatch,
+			maxDuration:   pc.MaxDurationPerBatch,
+			reservedAbove: reserved,
+		}
+		if classes[i].maxMessages == 0 {
+			classes[i].maxMessages = config.MaxMessagesPerBatch
+		}
+		if classes[i].maxDuration == 0 {
+			classes[i].maxDuration = config.MaxDurationPerBatch
+		}
+		reserved += pc.ReservedConcurrency
+	}
+	if reserved >= config.MaxConcurrency {
+		return classes, fmt.Errorf("reserved concurrency %d leaves no room within MaxConcurrency %d", reserved, config.MaxConcurrency)
+	}
+	return classes, nil
+}
+
+// WithPriority sends a message with priority p, taking precedence over
+// TypePriorities.
+func WithPriority(p Priority) SendOption {
+	return func(o *sendOptions) {
+		o.priority = p
+		o.hasPriority = true
+	}
+}
+
+// priorityOf resolves the priority of m sent with opts.
+func (bc *BufferedClient) priorityOf(m *Message, opts sendOptions) (Priority, error) {
+	p := PriorityNormal
+	if opts.hasPriority {
+		p = opts.priority
+	} else if tp, ok := bc.conf.TypePriorities[m.Type]; ok {
+		p = tp
+	}
+	if !p.valid() {
+		return p, fmt.Errorf("unknown priority %d", int(p))
+	}
+	return p, nil
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDispatcherPriorityOrder(t *testing.T) {
+	d := newDispatcher(10, 0)
+	for _, p := range []Priority{PriorityLow, PriorityNormal, PriorityHigh, PriorityLow, PriorityHigh} {
+		d.push(&batch{prio: p, lane: noLane})
+	}
+
+	var got []Priority
+	for b := d.next(func(Priority) bool { return true }); b != nil; b = d.next(func(Priority) bool { return true }) {
+		got = append(got, b.prio)
+	}
+	want := []Priority{PriorityHigh, PriorityHigh, PriorityNormal, PriorityLow, PriorityLow}
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	d.push(&batch{prio: PriorityLow, lane: noLane})
+	if b := d.next(func(p Priority) bool { return p == PriorityHigh }); b != nil {
+		t.Fatal("expected no slot for low priority")
+	}
+}
+
+func TestQueueShedsLowerPriorityFirst(t *testing.T) {
+	ctx := context.Background()
+	q := newQueue(2, 0, OverflowDropNewest)
+
+	low := newSendFuture()
+	q.push(ctx, &envelope{msg: newTestMessage(0), prio: PriorityLow}, true)
+	q.push(ctx, &envelope{msg: newTestMessage(1), prio: PriorityLow, future: low}, true)
+	q.push(ctx, &envelope{msg: newTestMessage(2), prio: PriorityHigh}, true)
+	if !errors.Is(low.Err(), ErrDropped) {
+		t.Fatalf("expected the newest low priority message to be dropped, got %v", low.Err())
+	}
+
+	normal := newSendFuture()
+	q.push(ctx, &envelope{msg: newTestMessage(3), prio: PriorityLow, future: normal}, true)
+	if !errors.Is(normal.Err(), ErrDropped) {
+		t.Fatalf("expected the incoming message to be dropped, got %v", normal.Err())
+	}
+
+	es := takeReleased(q, 10)
+	if len(es) != 2 || es[0].prio != PriorityHigh || es[1].prio != PriorityLow {
+		t.Fatal("expected high priority messages to be taken first")
+	}
+}
+
+func TestReservedConcurrency(t *testing.T) {
+	srv := newFakeIngest(t)
+	gate := make(chan struct{})
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		if b.Messages[0].Type == "debug" {
+			<-gate
+		}
+		srv.record(b)
+	})
+
+	conf := testBufferedConfig(srv.URL)
+	conf.MaxMessagesPerBatch = 1
+	conf.MaxConcurrency = 2
+	conf.TypePriorities = map[string]Priority{"debug": PriorityLow}
+	conf.Priorities = map[Priority]PriorityConfig{PriorityHigh: {ReservedConcurrency: 1}}
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close(context.Background())
+	defer close(gate)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for i := 0; i < 5; i++ {
+		if err := bc.Send(ctx, &Message{Type: "debug", Data: map[string]interface{}{"i": i}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bc.SendAndWait(ctx, newTestMessage(0), WithPriority(PriorityHigh)); err != nil {
+		t.Fatalf("high priority message starved by low priority traffic: %v", err)
+	}
+}
+
+func TestReservedConcurrencyWithFullClass(t *testing.T) {
+	srv := newFakeIngest(t)
+	gate := make(chan struct{})
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		if b.Messages[0].Type == "debug" {
+			<-gate
+		}
+		srv.record(b)
+	})
+
+	conf := testBufferedConfig(srv.URL)
+	conf.MaxMessagesPerBatch = 1
+	conf.MaxConcurrency = 2
+	conf.TypePriorities = map[string]Priority{"debug": PriorityLow}
+	conf.Priorities = map[Priority]PriorityConfig{PriorityHigh: {ReservedConcurrency: 1}}
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close(context.Background())
+	defer close(gate)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for i := 0; i < 8; i++ {
+		if err := bc.Send(ctx, &Message{Type: "debug", Data: map[string]interface{}{"i": i}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// one low batch in flight, as many ready as the class may hold, the rest
+	// left in the queue
+	for s := bc.Stats(); s.PendingBatches != 1+2*conf.MaxConcurrency || s.QueuedMessages != 3; s = bc.Stats() {
+		if ctx.Err() != nil {
+			t.Fatalf("low priority batches not sealed: %+v", s)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := bc.SendAndWait(ctx, newTestMessage(0), WithPriority(PriorityHigh)); err != nil {
+		t.Fatalf("high priority message held up by low priority batches: %v", err)
+	}
+}
@@ -0,0 +1,372 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// OverflowPolicy decides what happens to a message sent while the queue of
+// a BufferedClient is full.
+type OverflowPolicy int
+
+// Drop policies shed lower priority messages first: a message is never
+// dropped to make room for one of lower priority. Send returns ErrDropped when
+// the message being sent is the one dropped, and nil when an older message
+// made room for it.
+const (
+	OverflowBlock      OverflowPolicy = iota // wait for room, bounded by the ctx passed to Send
+	OverflowDropNewest                       // drop the message being sent, or the newest one of a lower priority
+	OverflowDropOldest                       // drop the oldest queued messages of the lowest priority to make room, or the message being sent
+	OverflowError                            // fail Send with ErrQueueFull
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop newest"
+	case OverflowDropOldest:
+		return "drop oldest"
+	case OverflowError:
+		return "error"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
+var (
+	// ErrQueueFull is returned when a message cannot be queued without
+	// blocking.
+	ErrQueueFull = errors.New("queue is full")
+
+	// ErrDropped is returned by Send and resolves the future of a message
+	// dropped by the overflow policy or the memory budget.
+	ErrDropped = errors.New("message was dropped")
+)
+
+// queue is the bounded in-memory queue between Send and the batching loop,
+// with a FIFO per priority class sharing the bounds.
+type queue struct {
+	maxItems int
+	maxBytes int
+	policy   OverflowPolicy
+
+	mu      sync.Mutex
+	items   [numPriorities][]*envelope
+	count   int // queued and held messages
+	bytes   int
+	held    int // taken messages not released yet
+	closed  bool
+	spaceCh chan struct{} // closed when room is made, created on demand by blocked senders
+
+	readyCh chan struct{} // signaled when items are pushed
+
+	onDrop func(*envelope) // called for every dropped message, optional
+
+	// onWatermark is called when the queue rises to highItems or highBytes,
+	// and again once it is back below half of them. Optional. It is called
+	// in order and without mu held, so that a slow callback never blocks
+	// Send or take.
+	onWatermark func(depth int, above bool)
+	highItems   int
+	highBytes   int
+	above       bool
+	crossings   []crossing // not reported yet
+	notifying   bool       // a goroutine is reporting crossings
+}
+
+// crossing is a change of side of the high watermark.
+type crossing struct {
+	depth int
+	above bool
+}
+
+func newQueue(maxItems, maxBytes int, policy OverflowPolicy) *queue {
+	return &queue{
+		maxItems: maxItems,
+		maxBytes: maxBytes,
+		policy:   policy,
+		readyCh:  make(chan struct{}, 1),
+	}
+}
+
+// push queues e according to the overflow policy. It returns ErrDropped after
+// dropping e itself. When block is false the block policy behaves like
+// OverflowError.
+func (q *queue) push(ctx context.Context, e *envelope, block bool) error {
+	if q.maxBytes > 0 && e.size > q.maxBytes {
+		return fmt.Errorf("message of %d bytes exceeds queue limit of %d bytes", e.size, q.maxBytes)
+	}
+
+	q.mu.Lock()
+	for {
+		if q.closed {
+			q.mu.Unlock()
+			return ErrClosed
+		}
+		if q.fits(e) {
+			break
+		}
+
+		switch q.policy {
+		case OverflowDropNewest:
+			if q.shed(e.prio, false) {
+				continue
+			}
+			q.mu.Unlock()
+			q.drop(e)
+			return ErrDropped
+		case OverflowDropOldest:
+			if q.shed(e.prio, true) {
+				continue
+			}
+			q.mu.Unlock()
+			q.drop(e)
+			return ErrDropped
+		case OverflowBlock:
+			if block {
+				if q.spaceCh == nil {
+					q.spaceCh = make(chan struct{})
+				}
+				spaceCh := q.spaceCh
+				q.mu.Unlock()
+
+				select {
+				case <-spaceCh:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				q.mu.Lock()
+				continue
+			}
+		}
+
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+
+	q.items[e.prio.index()] = append(q.items[e.prio.index()], e)
+	q.count++
+	q.bytes += e.size
+	crossed := q.watermark()
+	q.mu.Unlock()
+	if crossed {
+		q.notify()
+	}
+
+	select {
+	case q.readyCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// shed drops a queued message to make room for one of priority p. With
+// oldest it drops the oldest message of the lowest priority up to p, otherwise
+// the newest message of the lowest priority below p. Caller must hold mu.
+func (q *queue) shed(p Priority, oldest bool) bool {
+	for i := 0; i < numPriorities; i++ {
+		items := q.items[i]
+		if len(items) == 0 {
+			continue
+		}
+		if i > p.index() || (i == p.index() && !oldest) {
+			return false
+		}
+
+		var victim *envelope
+		if oldest {
+			victim = items[0]
+			items[0] = nil
+			q.items[i] = items[1:]
+		} else {
+			victim = items[len(items)-1]
+			items[len(items)-1] = nil
+			q.items[i] = items[:len(items)-1]
+		}
+		q.count--
+		q.bytes -= victim.size
+		q.drop(victim)
+		return true
+	}
+	return false
+}
+
+// evict drops the oldest message of the lowest priority up to p, for the
+// memory budget.
+func (q *queue) evict(p Priority) bool {
+	q.mu.Lock()
+	if !q.shed(p, true) {
+		q.mu.Unlock()
+		return false
+	}
+	crossed := q.watermark()
+	if q.spaceCh != nil {
+		close(q.spaceCh)
+		q.spaceCh = nil
+	}
+	q.mu.Unlock()
+	if crossed {
+		q.notify()
+	}
+	return true
+}
+
+// watermark records crossings of the high watermark, it tells whether some
+// are left for notify to report. Caller must hold mu.
+func (q *queue) watermark() bool {
+	if q.onWatermark == nil {
+		return false
+	}
+
+	high := (q.highItems > 0 && q.count >= q.highItems) || (q.highBytes > 0 && q.bytes >= q.highBytes)
+	low := (q.highItems == 0 || q.count < q.highItems/2) && (q.highBytes == 0 || q.bytes < q.highBytes/2)
+	switch {
+	case !q.above && high:
+		q.above = true
+		q.crossings = append(q.crossings, crossing{depth: q.count, above: true})
+	case q.above && low:
+		q.above = false
+		q.crossings = append(q.crossings, crossing{depth: q.count, above: false})
+	}
+	return len(q.crossings) > 0
+}
+
+// notify reports the recorded crossings. Caller must not hold mu. Only one
+// goroutine reports at a time, the others leave their crossings to it.
+func (q *queue) notify() {
+	q.mu.Lock()
+	if q.notifying || len(q.crossings) == 0 {
+		q.mu.Unlock()
+		return
+	}
+	q.notifying = true
+	for len(q.crossings) > 0 {
+		crossings := q.crossings
+		q.crossings = nil
+		q.mu.Unlock()
+		for _, c := range crossings {
+			q.onWatermark(c.depth, c.above)
+		}
+		q.mu.Lock()
+	}
+	q.notifying = false
+	q.mu.Unlock()
+}
+
+func (q *queue) drop(e *envelope) {
+	if q.onDrop != nil {
+		q.onDrop(e)
+		return
+	}
+	e.drop(ErrDropped)
+}
+
+func (q *queue) fits(e *envelope) bool {
+	if q.maxItems > 0 && q.count >= q.maxItems {
+		return false
+	}
+	if q.maxBytes > 0 && q.bytes+e.size > q.maxBytes {
+		return false
+	}
+	return true
+}
+
+// ready is signaled whenever messages may be available to take.
+func (q *queue) ready() <-chan struct{} {
+	return q.readyCh
+}
+
+// take removes up to n messages of the priority classes set in classes
+// from the head of the queue, higher priorities first. They still count
+// toward the limits of the queue until release is called for them, once
+// batched or dropped. The ready channel is only signaled again for messages
+// of those classes.
+func (q *queue) take(n int, classes [numPriorities]bool) []*envelope {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if n > q.count-q.held {
+		n = q.count - q.held
+	}
+	if n == 0 {
+		return nil
+	}
+
+	out := make([]*envelope, 0, n)
+	left := 0
+	for i := numPriorities - 1; i >= 0; i-- {
+		if !classes[i] {
+			continue
+		}
+		items := q.items[i]
+		k := n - len(out)
+		if k > len(items) {
+			k = len(items)
+		}
+		out = append(out, items[:k]...)
+		for j := 0; j < k; j++ {
+			items[j] = nil
+		}
+		q.items[i] = items[k:]
+		left += len(q.items[i])
+	}
+	q.held += len(out)
+
+	if left > 0 {
+		select {
+		case q.readyCh <- struct{}{}:
+		default:
+		}
+	}
+	return out
+}
+
+// release stops counting n taken messages of the given encoded size.
+func (q *queue) release(n, bytes int) {
+	if n == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	q.held -= n
+	q.count -= n
+	q.bytes -= bytes
+	crossed := q.watermark()
+	if q.spaceCh != nil {
+		close(q.spaceCh)
+		q.spaceCh = nil
+	}
+	q.mu.Unlock()
+	if crossed {
+		q.notify()
+	}
+}
+
+// close rejects further pushes and wakes up blocked senders. Messages
+// already queued can still be taken.
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	if q.spaceCh != nil {
+		close(q.spaceCh)
+		q.spaceCh = nil
+	}
+}
+
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count
+}
+
+// size returns the number of queued messages and their encoded size.
+func (q *queue) size() (items, bytes int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count, q.bytes
+}
@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueueOverflowPolicies(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("error", func(t *testing.T) {
+		q := newQueue(2, 0, OverflowError)
+		for i := 0; i < 2; i++ {
+			if err := q.push(ctx, &envelope{msg: newTestMessage(i)}, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := q.push(ctx, &envelope{msg: newTestMessage(2)}, true); !errors.Is(err, ErrQueueFull) {
+			t.Fatalf("expected ErrQueueFull, got %v", err)
+		}
+	})
+
+	t.Run("drop-newest", func(t *testing.T) {
+		q := newQueue(1, 0, OverflowDropNewest)
+		q.push(ctx, &envelope{msg: newTestMessage(0)}, true)
+
+		f := newSendFuture()
+		if err := q.push(ctx, &envelope{msg: newTestMessage(1), future: f}, true); !errors.Is(err, ErrDropped) {
+			t.Fatalf("expected ErrDropped, got %v", err)
+		}
+		if !errors.Is(f.Err(), ErrDropped) {
+			t.Fatalf("expected dropped message, got %v", f.Err())
+		}
+		if es := takeReleased(q, 10); len(es) != 1 || es[0].msg.Data.(map[string]interface{})["i"] != 0 {
+			t.Fatal("expected the oldest message to be kept")
+		}
+	})
+
+	t.Run("drop-oldest", func(t *testing.T) {
+		q := newQueue(0, 10, OverflowDropOldest)
+		f := newSendFuture()
+		q.push(ctx, &envelope{msg: newTestMessage(0), future: f, size: 6}, true)
+		if err := q.push(ctx, &envelope{msg: newTestMessage(1), size: 6}, true); err != nil {
+			t.Fatalf("expected the message to be queued, got %v", err)
+		}
+
+		if !errors.Is(f.Err(), ErrDropped) {
+			t.Fatalf("expected dropped message, got %v", f.Err())
+		}
+		if es := takeReleased(q, 10); len(es) != 1 || es[0].msg.Data.(map[string]interface{})["i"] != 1 {
+			t.Fatal("expected the newest message to be kept")
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		q := newQueue(1, 0, OverflowBlock)
+		q.push(ctx, &envelope{msg: newTestMessage(0)}, true)
+
+		if err := q.push(ctx, &envelope{msg: newTestMessage(1)}, false); !errors.Is(err, ErrQueueFull) {
+			t.Fatalf("expected ErrQueueFull without blocking, got %v", err)
+		}
+
+		tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if err := q.push(tctx, &envelope{msg: newTestMessage(1)}, true); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected push to block until deadline, got %v", err)
+		}
+
+		done := make(chan error)
+		go func() { done <- q.push(ctx, &envelope{msg: newTestMessage(2)}, true) }()
+		time.Sleep(5 * time.Millisecond)
+		takeReleased(q, 1)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestQueueWatermarkOutsideLock(t *testing.T) {
+	ctx := context.Background()
+	q := newQueue(10, 0, OverflowError)
+	q.highItems = 2
+
+	release := make(chan struct{})
+	var calls []bool
+	q.onWatermark = func(depth int, above bool) {
+		calls = append(calls, above)
+		if above {
+			<-release // a stuck logger
+		}
+	}
+
+	q.push(ctx, &envelope{msg: newTestMessage(0)}, true)
+	done := make(chan struct{})
+	go func() {
+		q.push(ctx, &envelope{msg: newTestMessage(1)}, true)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// the queue keeps working while the callback is stuck
+	if err := q.push(ctx, &envelope{msg: newTestMessage(2)}, true); err != nil {
+		t.Fatal(err)
+	}
+	if es := takeReleased(q, 3); len(es) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(es))
+	}
+
+	close(release)
+	<-done
+	if len(calls) != 2 || !calls[0] || calls[1] {
+		t.Fatalf("expected the crossings to be reported in order, got %v", calls)
+	}
+}
+
+func TestQueueCountsTakenUntilReleased(t *testing.T) {
+	ctx := context.Background()
+	q := newQueue(2, 0, OverflowError)
+	q.push(ctx, &envelope{msg: newTestMessage(0), prio: PriorityLow}, true)
+	q.push(ctx, &envelope{msg: newTestMessage(1), prio: PriorityHigh}, true)
+
+	var low [numPriorities]bool
+	low[PriorityLow.index()] = true
+	if es := q.take(10, low); len(es) != 1 || es[0].prio != PriorityLow {
+		t.Fatal("expected only the low priority message to be taken")
+	}
+	if err := q.push(ctx, &envelope{msg: newTestMessage(2)}, true); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected a held message to count toward the limit, got %v", err)
+	}
+	q.release(1, 0)
+	if err := q.push(ctx, &envelope{msg: newTestMessage(2)}, true); err != nil {
+		t.Fatal(err)
+	}
+	if q.len() != 2 {
+		t.Fatalf("expected 2 queued messages, got %d", q.len())
+	}
+}
+
+// takeReleased takes up to n messages of q and releases them at once, as the
+// batching loop does with the messages it batches.
+func takeReleased(q *queue, n int) []*envelope {
+	es := q.take(n, allPriorities)
+	size := 0
+	for _, e := range es {
+		size += e.size
+	}
+	q.release(len(es), size)
+	return es
+}
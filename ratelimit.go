@@ -0,0 +1,291 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RateLimit caps the throughput of messages, zero fields mean unlimited.
+// Bytes are counted as the JSON encoded size of each message.
+type RateLimit struct {
+	MessagesPerSecond float64
+	BytesPerSecond    float64
+	MessagesBurst     int // default is one second worth of MessagesPerSecond
+	BytesBurst        int // default is one second worth of BytesPerSecond
+}
+
+func (r RateLimit) bytesLimited() bool {
+	return r.BytesPerSecond > 0
+}
+
+// RateLimitPolicy decides what happens to messages beyond a rate limit.
+type RateLimitPolicy int
+
+const (
+	RateLimitDelay  RateLimitPolicy = iota // wait until the message fits in the limit, bounded by ctx
+	RateLimitReject                        // fail with ErrRateLimited, or ErrRateLimitBurst when the send can never fit
+)
+
+var (
+	// ErrRateLimited is returned when messages are rejected by a rate limit.
+	// The same send may succeed later.
+	ErrRateLimited = errors.New("rate limit exceeded")
+
+	// ErrRateLimitBurst is returned instead of ErrRateLimited when a send
+	// costs more messages or bytes than the burst of a limit, so that it
+	// would be rejected forever. Split it or raise the burst.
+	ErrRateLimitBurst = errors.New("send exceeds rate limit burst")
+)
+
+// RateLimitStat reports the usage of a rate limit.
+type RateLimitStat struct {
+	Type string // message type the limit applies to, empty for the global limit
+
+	Messages uint64 // messages let through
+	Bytes    uint64 // bytes let through
+	Delayed  uint64 // sends this limit made wait, but for those cancelled while waiting
+	Rejected uint64 // sends this limit rejected
+
+	AvailableMessages float64 // messages that can be sent right now, -1 when unlimited
+	AvailableBytes    float64 // bytes that can be sent right now, -1 when unlimited
+}
+
+// tokenBucket refills rate tokens per second up to burst. Tokens go negative
+// when reserved ahead of time by delayed sends.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(1, rate)
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+func (b *tokenBucket) advance(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// delay returns how long to wait for n tokens.
+func (b *tokenBucket) delay(n float64) time.Duration {
+	if b == nil || b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// exceeds tells whether n tokens are more than the bucket can ever hold.
+func (b *tokenBucket) exceeds(n float64) bool {
+	return b != nil && n > b.burst
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b != nil {
+		b.tokens -= n
+	}
+}
+
+func (b *tokenBucket) refund(n float64) {
+	if b != nil {
+		b.tokens = math.Min(b.burst, b.tokens+n)
+	}
+}
+
+func (b *tokenBucket) available() float64 {
+	if b == nil {
+		return -1
+	}
+	return b.tokens
+}
+
+// limitScope is a pair of buckets and their usage counters.
+type limitScope struct {
+	msgs  *tokenBucket
+	bytes *tokenBucket
+
+	stat RateLimitStat
+}
+
+func newLimitScope(typ string, r RateLimit) *limitScope {
+	return &limitScope{
+		msgs:  newTokenBucket(r.MessagesPerSecond, r.MessagesBurst),
+		bytes: newTokenBucket(r.BytesPerSecond, r.BytesBurst),
+		stat:  RateLimitStat{Type: typ},
+	}
+}
+
+// rateUsage is what a send costs to the limits of one message type.
+type rateUsage struct {
+	typ      string
+	messages int
+	bytes    int
+}
+
+// rateLimiter enforces a global limit and limits per message type.
+type rateLimiter struct {
+	policy        RateLimitPolicy
+	bytesLimited  bool
+	typesLimited  bool
+	globalLimited bool
+
+	mu     sync.Mutex
+	global *limitScope
+	types  map[string]*limitScope
+}
+
+// newRateLimiter returns nil when nothing is limited.
+func newRateLimiter(global RateLimit, types map[string]RateLimit, policy RateLimitPolicy) *rateLimiter {
+	l := &rateLimiter{
+		policy:        policy,
+		global:        newLimitScope("", global),
+		types:         make(map[string]*limitScope, len(types)),
+		bytesLimited:  global.bytesLimited(),
+		globalLimited: global.MessagesPerSecond > 0 || global.BytesPerSecond > 0,
+	}
+	for typ, r := range types {
+		if r.MessagesPerSecond <= 0 && r.BytesPerSecond <= 0 {
+			continue
+		}
+		l.types[typ] = newLimitScope(typ, r)
+		l.typesLimited = true
+		l.bytesLimited = l.bytesLimited || r.bytesLimited()
+	}
+	if !l.globalLimited && !l.typesLimited {
+		return nil
+	}
+	return l
+}
+
+// wait lets usage through the limits, delaying or rejecting it according to
+// the policy. A delay is only allowed when block is true.
+func (l *rateLimiter) wait(ctx context.Context, usage []rateUsage, block bool) error {
+	type cost struct {
+		scope           *limitScope
+		messages, bytes float64
+		limiting        bool // the scope itself has to delay usage
+	}
+	costs := make([]cost, 0, len(usage)+1)
+	total := cost{scope: l.global}
+	for _, u := range usage {
+		total.messages += float64(u.messages)
+		total.bytes += float64(u.bytes)
+		if scope, ok := l.types[u.typ]; ok {
+			costs = append(costs, cost{scope: scope, messages: float64(u.messages), bytes: float64(u.bytes)})
+		}
+	}
+	costs = append(costs, total)
+
+	l.mu.Lock()
+	now := time.Now()
+	var delay time.Duration
+	for i := range costs {
+		c := &costs[i]
+		c.scope.msgs.advance(now)
+		c.scope.bytes.advance(now)
+		d := c.scope.msgs.delay(c.messages)
+		if bd := c.scope.bytes.delay(c.bytes); bd > d {
+			d = bd
+		}
+		c.limiting = d > 0
+		if d > delay {
+			delay = d
+		}
+	}
+
+	// only the scopes over their limit count the rejection or the delay
+	if delay > 0 && (l.policy == RateLimitReject || !block) {
+		err := ErrRateLimited
+		for _, c := range costs {
+			if !c.limiting {
+				continue
+			}
+			c.scope.stat.Rejected++
+			if c.scope.msgs.exceeds(c.messages) || c.scope.bytes.exceeds(c.bytes) {
+				err = ErrRateLimitBurst
+			}
+		}
+		l.mu.Unlock()
+		return err
+	}
+
+	for _, c := range costs {
+		c.scope.msgs.take(c.messages)
+		c.scope.bytes.take(c.bytes)
+		c.scope.stat.Messages += uint64(c.messages)
+		c.scope.stat.Bytes += uint64(c.bytes)
+		if c.limiting {
+			c.scope.stat.Delayed++
+		}
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		for _, c := range costs {
+			c.scope.msgs.refund(c.messages)
+			c.scope.bytes.refund(c.bytes)
+			c.scope.stat.Messages -= uint64(c.messages)
+			c.scope.stat.Bytes -= uint64(c.bytes)
+			if c.limiting {
+				c.scope.stat.Delayed--
+			}
+		}
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (l *rateLimiter) stats() []RateLimitStat {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	scopes := make([]*limitScope, 0, len(l.types)+1)
+	if l.globalLimited {
+		scopes = append(scopes, l.global)
+	}
+	for _, scope := range l.types {
+		scopes = append(scopes, scope)
+	}
+
+	out := make([]RateLimitStat, 0, len(scopes))
+	for _, scope := range scopes {
+		scope.msgs.advance(now)
+		scope.bytes.advance(now)
+		stat := scope.stat
+		stat.AvailableMessages = scope.msgs.available()
+		stat.AvailableBytes = scope.bytes.available()
+		out = append(out, stat)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Type < out[j].Type })
+	return out
+}
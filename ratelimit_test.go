@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReject(t *testing.T) {
+	l := newRateLimiter(
+		RateLimit{MessagesPerSecond: 1, MessagesBurst: 3},
+		map[string]RateLimit{"Debug": {MessagesPerSecond: 1, MessagesBurst: 1}},
+		RateLimitReject,
+	)
+	ctx := context.Background()
+
+	if err := l.wait(ctx, []rateUsage{{typ: "Debug", messages: 1}}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.wait(ctx, []rateUsage{{typ: "Debug", messages: 1}}, true); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected per type limit to reject, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := l.wait(ctx, []rateUsage{{typ: "Event", messages: 1}}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.wait(ctx, []rateUsage{{typ: "Event", messages: 1}}, true); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected global limit to reject, got %v", err)
+	}
+
+	stats := l.stats()
+	if len(stats) != 2 || stats[0].Type != "" || stats[1].Type != "Debug" {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	// a rejection only counts on the scope over its limit
+	if stats[0].Messages != 3 || stats[0].Rejected != 1 || stats[1].Messages != 1 || stats[1].Rejected != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats[1].AvailableBytes != -1 {
+		t.Fatalf("expected bytes to be unlimited, got %v", stats[1].AvailableBytes)
+	}
+}
+
+func TestRateLimiterRejectLargerThanBurst(t *testing.T) {
+	l := newRateLimiter(RateLimit{MessagesPerSecond: 100, MessagesBurst: 10}, nil, RateLimitReject)
+	ctx := context.Background()
+
+	// the limiter is idle, yet 11 messages never fit in a burst of 10
+	err := l.wait(ctx, []rateUsage{{typ: "Event", messages: 11}}, true)
+	if !errors.Is(err, ErrRateLimitBurst) || errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimitBurst, got %v", err)
+	}
+	if err := l.wait(ctx, []rateUsage{{typ: "Event", messages: 10}}, true); err != nil {
+		t.Fatalf("expected a batch of the burst size to pass, got %v", err)
+	}
+	if err := l.wait(ctx, []rateUsage{{typ: "Event", messages: 5}}, true); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited once the tokens are used, got %v", err)
+	}
+}
+
+func TestRateLimiterDelay(t *testing.T) {
+	l := newRateLimiter(RateLimit{BytesPerSecond: 1000, BytesBurst: 100}, nil, RateLimitDelay)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.wait(ctx, []rateUsage{{messages: 1, bytes: 100}}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected sends to be delayed, took %s", elapsed)
+	}
+
+	if err := l.wait(ctx, []rateUsage{{messages: 1, bytes: 100}}, false); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected non blocking send to be rejected, got %v", err)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if err := l.wait(tctx, []rateUsage{{messages: 1, bytes: 1000}}, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected delay to be bounded by ctx, got %v", err)
+	}
+	// the cancelled send is not counted
+	if stats := l.stats(); stats[0].Bytes != 300 || stats[0].Delayed != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
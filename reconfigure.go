@@ -0,0 +1,143 @@
+package client
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// RuntimeConfig holds the settings of a BufferedClient that Reconfigure can
+// change while it runs. Zero fields keep their current value.
+type RuntimeConfig struct {
+	MaxMessagesPerBatch int
+	MaxDurationPerBatch time.Duration
+	MaxConcurrency      int
+
+	Endpoint        string
+	AccessKeyID     string
+	AccessKeySecret string
+}
+
+// batchLimits are the batching settings handed to the batching loop.
+type batchLimits struct {
+	maxMessages int
+	prio        [numPriorities]priorityClass
+}
+
+// Pause stops sending batches until Resume is called. Messages keep being
+// queued and batched meanwhile, within the bounds of the queue, and
+// requests already in flight complete. Close still gives up on paused batches
+// once its ctx is done.
+func (bc *BufferedClient) Pause() {
+	bc.pauseMu.Lock()
+	defer bc.pauseMu.Unlock()
+
+	if bc.resumeCh == nil {
+		bc.resumeCh = make(chan struct{})
+		bc.conf.Logger.Info("delivery paused")
+	}
+}
+
+// Resume restarts sending batches after Pause.
+func (bc *BufferedClient) Resume() {
+	bc.pauseMu.Lock()
+	defer bc.pauseMu.Unlock()
+
+	if bc.resumeCh != nil {
+		close(bc.resumeCh)
+		bc.resumeCh = nil
+		bc.conf.Logger.Info("delivery resumed")
+	}
+}
+
+// Paused tells whether delivery is paused.
+func (bc *BufferedClient) Paused() bool {
+	bc.pauseMu.Lock()
+	defer bc.pauseMu.Unlock()
+	return bc.resumeCh != nil
+}
+
+// resumed returns a channel closed on Resume while delivery is paused, nil
+// otherwise. Once Close has given up, paused batches are let through to be
+// settled.
+func (bc *BufferedClient) resumed() <-chan struct{} {
+	if bc.ctx.Err() != nil {
+		return nil
+	}
+
+	bc.pauseMu.Lock()
+	defer bc.pauseMu.Unlock()
+	return bc.resumeCh
+}
+
+// Reconfigure changes batching, concurrency, endpoint and credentials without
+// losing buffered messages. Batches already sealed keep their size, requests
+// in flight complete against the previous endpoint. Nothing is changed when
+// it returns an error, but for the batch limits of a client being closed.
+func (bc *BufferedClient) Reconfigure(rc RuntimeConfig) error {
+	bc.reconfigureMu.Lock()
+	defer bc.reconfigureMu.Unlock()
+
+	if atomic.LoadInt32(&bc.state) != stateRunning {
+		return ErrClosed
+	}
+	if rc.MaxMessagesPerBatch < 0 || rc.MaxDurationPerBatch < 0 || rc.MaxConcurrency < 0 {
+		return fmt.Errorf("negative runtime config")
+	}
+
+	conf := bc.conf
+	if rc.MaxMessagesPerBatch > 0 {
+		conf.MaxMessagesPerBatch = rc.MaxMessagesPerBatch
+	}
+	if rc.MaxDurationPerBatch > 0 {
+		conf.MaxDurationPerBatch = rc.MaxDurationPerBatch
+	}
+	if rc.MaxConcurrency > 0 {
+		conf.MaxConcurrency = rc.MaxConcurrency
+	}
+	if conf.MinConcurrency > conf.MaxConcurrency {
+		return fmt.Errorf("MinConcurrency %d is greater than MaxConcurrency %d", conf.MinConcurrency, conf.MaxConcurrency)
+	}
+	prio, err := resolvePriorities(conf)
+	if err != nil {
+		return err
+	}
+
+	// the batching loops may stop taking new limits only once the client is
+	// closing, the other changes cannot fail and are applied after them
+	if conf.MaxMessagesPerBatch != bc.conf.MaxMessagesPerBatch || conf.MaxDurationPerBatch != bc.conf.MaxDurationPerBatch {
+		for _, s := range bc.shards {
+			select {
+			case s.limitsCh <- batchLimits{maxMessages: conf.MaxMessagesPerBatch, prio: prio}:
+			case <-bc.closeCh:
+				return ErrClosed
+			}
+		}
+	}
+	if rc.Endpoint != "" || rc.AccessKeyID != "" || rc.AccessKeySecret != "" {
+		endpoint, accessKeyID, accessKeySecret := bc.client.target()
+		if rc.Endpoint != "" {
+			endpoint = rc.Endpoint
+		}
+		if rc.AccessKeyID != "" {
+			accessKeyID = rc.AccessKeyID
+		}
+		if rc.AccessKeySecret != "" {
+			accessKeySecret = rc.AccessKeySecret
+		}
+		bc.client.setTarget(endpoint, accessKeyID, accessKeySecret)
+	}
+	if conf.MaxConcurrency != bc.conf.MaxConcurrency {
+		bc.limiter.setMax(conf.MaxConcurrency)
+		bc.dispatcher.setMaxReady(2 * conf.MaxConcurrency)
+	}
+
+	bc.conf.MaxMessagesPerBatch = conf.MaxMessagesPerBatch
+	bc.conf.MaxDurationPerBatch = conf.MaxDurationPerBatch
+	bc.conf.MaxConcurrency = conf.MaxConcurrency
+	bc.conf.Logger.WithField("maxMessagesPerBatch", conf.MaxMessagesPerBatch).
+		WithField("maxDurationPerBatch", conf.MaxDurationPerBatch.String()).
+		WithField("maxConcurrency", conf.MaxConcurrency).
+		Info("client reconfigured")
+	return nil
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPauseResume(t *testing.T) {
+	srv := newFakeIngest(t)
+	bc, err := NewBufferedClient(testBufferedConfig(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	bc.Pause()
+	f := bc.SendAsync(ctx, newTestMessage(0))
+	time.Sleep(30 * time.Millisecond)
+	if srv.received() != 0 || f.Err() != nil {
+		t.Fatal("expected nothing to be sent while paused")
+	}
+
+	bc.Resume()
+	if err := f.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if srv.received() != 1 {
+		t.Fatalf("expected 1 message after resume, got %d", srv.received())
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	before, after := newFakeIngest(t), newFakeIngest(t)
+	conf := testBufferedConfig(before.URL)
+	conf.MaxDurationPerBatch = time.Hour
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close(context.Background())
+
+	if err := bc.Reconfigure(RuntimeConfig{MaxConcurrency: -1}); err == nil {
+		t.Fatal("expected invalid config to be rejected")
+	}
+	if err := bc.Reconfigure(RuntimeConfig{Endpoint: after.URL, MaxMessagesPerBatch: 2, MaxConcurrency: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	f := bc.SendAsync(ctx, newTestMessage(0))
+	// sealed by the new batch size, long before MaxDurationPerBatch
+	if err := bc.SendAndWait(ctx, newTestMessage(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if before.received() != 0 || after.received() != 2 {
+		t.Fatalf("expected messages to go to the new endpoint, got %d and %d", before.received(), after.received())
+	}
+	if bc.ConcurrencyLimit() != 4 {
+		t.Fatalf("expected concurrency 4, got %d", bc.ConcurrencyLimit())
+	}
+}
@@ -0,0 +1,218 @@
+package client
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// retryQueue holds batches waiting for their next attempt, ordered by when
+// that attempt is due.
+type retryQueue struct {
+	mu    sync.Mutex
+	items retryHeap
+
+	wakeCh chan struct{}
+}
+
+func newRetryQueue() *retryQueue {
+	return &retryQueue{wakeCh: make(chan struct{}, 1)}
+}
+
+func (q *retryQueue) push(b *batch) {
+	q.mu.Lock()
+	heap.Push(&q.items, b)
+	q.mu.Unlock()
+	q.notify()
+}
+
+// popDue returns the next batch due at now, or how long to wait for one.
+// Both are zero when the queue is empty.
+func (q *retryQueue) popDue(now time.Time) (*batch, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return nil, 0
+	}
+	if wait := q.items[0].nextAttempt.Sub(now); wait > 0 {
+		return nil, wait
+	}
+	return heap.Pop(&q.items).(*batch), 0
+}
+
+// shed removes the batch of the lowest priority up to p, oldest first.
+func (q *retryQueue) shed(p Priority) *batch {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	victim := -1
+	for i, b := range q.items {
+		if b.prio > p {
+			continue
+		}
+		if victim < 0 || b.prio < q.items[victim].prio ||
+			(b.prio == q.items[victim].prio && b.createdAt.Before(q.items[victim].createdAt)) {
+			victim = i
+		}
+	}
+	if victim < 0 {
+		return nil
+	}
+	return heap.Remove(&q.items, victim).(*batch)
+}
+
+func (q *retryQueue) drain() []*batch {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := q.items
+	q.items = nil
+	return items
+}
+
+func (q *retryQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *retryQueue) notify() {
+	select {
+	case q.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (q *retryQueue) wake() <-chan struct{} {
+	return q.wakeCh
+}
+
+type retryHeap []*batch
+
+func (h retryHeap) Len() int            { return len(h) }
+func (h retryHeap) Less(i, j int) bool  { return h[i].nextAttempt.Before(h[j].nextAttempt) }
+func (h retryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *retryHeap) Push(x interface{}) { *h = append(*h, x.(*batch)) }
+func (h *retryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	b := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return b
+}
+
+// scheduleRetry queues b for another attempt after its backoff.
+func (bc *BufferedClient) scheduleRetry(b *batch, err error) {
+	if b.backoff == 0 {
+		b.backoff = bc.conf.RetryTimeIntervalInitial
+	}
+	b.backoff *= 2
+	if b.backoff >= bc.conf.RetryTimeIntervalMax {
+		b.backoff = bc.conf.RetryTimeIntervalMax
+	}
+	bc.pendingMu.Lock()
+	b.nextAttempt = time.Now().Add(b.backoff)
+	bc.pendingMu.Unlock()
+
+	if bc.ctx.Err() != nil {
+		bc.giveUp(b, bc.ctx.Err())
+		return
+	}
+
+	bc.client.retryLog.failed(b.BatchId, b.backoff, err)
+	if bc.events.active() {
+		e := batchEvent(EventRetryScheduled, b)
+		e.Attempt, e.Backoff, e.Err = b.attempts, b.backoff, err
+		bc.events.emit(e)
+	}
+	bc.retries.push(b)
+}
+
+// retryLoop runs the attempts of failed batches on its own pool of
+// RetryConcurrency workers, so that failing batches never hold the slots of
+// fresh traffic. It exits once the sending loop is done and no batch is left
+// to retry.
+func (bc *BufferedClient) retryLoop() {
+	defer bc.conf.Logger.Debug("retry loop exited")
+	defer close(bc.retryLoopDie)
+	defer bc.events.close()
+	defer atomic.StoreInt32(&bc.state, stateClosed)
+	if bc.spool != nil {
+		defer bc.spool.close()
+	}
+
+	sema := make(chan interface{}, bc.conf.RetryConcurrency)
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	var inflight int64
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	sendingLoopDie := bc.sendingLoopDie
+	ctxDone := bc.ctx.Done()
+
+	for {
+		if sendingLoopDie == nil && bc.retries.len() == 0 && atomic.LoadInt64(&inflight) == 0 {
+			return
+		}
+
+		if resumed := bc.resumed(); resumed != nil {
+			select {
+			case <-resumed:
+			case <-bc.ctx.Done():
+			}
+			continue
+		}
+
+		b, wait := bc.retries.popDue(time.Now())
+		if b != nil {
+			select {
+			case sema <- nil:
+			case <-bc.ctx.Done():
+				bc.giveUp(b, bc.ctx.Err())
+				continue
+			}
+			atomic.AddInt64(&inflight, 1)
+			wg.Add(1)
+
+			go func() {
+				defer func() {
+					<-sema
+					atomic.AddInt64(&inflight, -1)
+					wg.Done()
+					bc.retries.notify()
+				}()
+				bc.attempt(b)
+			}()
+			continue
+		}
+
+		var timerC <-chan time.Time
+		if wait > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+			timerC = timer.C
+		}
+
+		select {
+		case <-bc.retries.wake():
+		case <-timerC:
+		case <-sendingLoopDie:
+			sendingLoopDie = nil
+		case <-ctxDone:
+			// in-flight attempts are cancelled too, they report back through wake
+			ctxDone = nil
+			for _, b := range bc.retries.drain() {
+				bc.giveUp(b, bc.ctx.Err())
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetriesDoNotBlockFreshTraffic(t *testing.T) {
+	srv := newFakeIngest(t)
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		if b.Messages[0].Data.(map[string]interface{})["#event"] == "poison" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		srv.record(b)
+	})
+
+	conf := testBufferedConfig(srv.URL)
+	conf.MaxMessagesPerBatch = 1
+	conf.MaxConcurrency = 1
+	conf.RetryConcurrency = 1
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var poisoned []*SendFuture
+	for i := 0; i < 3; i++ {
+		poisoned = append(poisoned, bc.SendAsync(ctx, &Message{Type: "Event", Data: map[string]interface{}{"#event": "poison"}}))
+	}
+	for i := 0; i < 3; i++ {
+		if err := bc.SendAndWait(ctx, newTestMessage(i)); err != nil {
+			t.Fatalf("healthy message was held up by retries: %v", err)
+		}
+	}
+
+	for _, f := range poisoned {
+		if f.Err() != nil {
+			t.Fatalf("retrying batch was settled: %v", f.Err())
+		}
+	}
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer closeCancel()
+	bc.Close(closeCtx)
+	for _, f := range poisoned {
+		if f.Err() == nil {
+			t.Fatal("retrying batch should be undelivered after Close")
+		}
+	}
+}
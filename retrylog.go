@@ -0,0 +1,103 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// retryLog collapses the warnings of failed attempts during an outage: the
+// first failure of an interval is logged as is, the following ones are
+// counted and logged as a single summary when the interval ends. Intervals
+// keep running while failures go on, the next failure after a quiet interval
+// is logged as is again.
+type retryLog struct {
+	logger   Logger
+	interval time.Duration // failures are all logged when not positive
+
+	mu      sync.Mutex
+	timer   *time.Timer // running while an interval is open
+	start   time.Time
+	retries int
+	batches map[string]struct{}
+	lastErr string
+}
+
+func newRetryLog(logger Logger, interval time.Duration) *retryLog {
+	return &retryLog{logger: logger, interval: interval, batches: make(map[string]struct{})}
+}
+
+// failed records a failed attempt of batchID retried after wait.
+func (r *retryLog) failed(batchID string, wait time.Duration, err error) {
+	if r.interval <= 0 {
+		r.warn(batchID, wait, err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.timer == nil {
+		r.warn(batchID, wait, err)
+		r.start = time.Now()
+		r.timer = time.AfterFunc(r.interval, r.summarize)
+		return
+	}
+	r.retries++
+	r.batches[batchID] = struct{}{}
+	r.lastErr = err.Error()
+}
+
+func (r *retryLog) warn(batchID string, wait time.Duration, err error) {
+	r.logger.WithField("err", err.Error()).WithField("wait", wait).WithField("batchId", batchID).Warn("failed to send request, retry later")
+}
+
+// summarize logs the failures counted since the interval started, and starts
+// another one if there were any.
+func (r *retryLog) summarize() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.timer == nil {
+		return
+	}
+	if r.retries == 0 {
+		r.timer = nil
+		return
+	}
+	r.flushLocked()
+	r.start = time.Now()
+	r.timer.Reset(r.interval)
+}
+
+// flush logs the pending summary, if any, and closes the interval.
+func (r *retryLog) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.timer == nil {
+		return
+	}
+	r.timer.Stop()
+	r.timer = nil
+	if r.retries > 0 {
+		r.flushLocked()
+	}
+}
+
+func (r *retryLog) flushLocked() {
+	elapsed := time.Since(r.start)
+	if elapsed >= time.Second {
+		elapsed = elapsed.Round(time.Second)
+	} else {
+		elapsed = elapsed.Round(time.Millisecond)
+	}
+	r.logger.WithField("retries", r.retries).
+		WithField("batches", len(r.batches)).
+		WithField("lastError", r.lastErr).
+		Warn(fmt.Sprintf("%d retries for %d batches in last %s, last error: %s", r.retries, len(r.batches), elapsed, r.lastErr))
+
+	r.retries = 0
+	r.batches = make(map[string]struct{})
+	r.lastErr = ""
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryLogSummarizes(t *testing.T) {
+	var buf bytes.Buffer
+	r := newRetryLog(NewLogger(&buf, LevelWarn), time.Hour)
+
+	err := errors.New("http code: 503: unavailable")
+	for i := 0; i < 100; i++ {
+		r.failed([]string{"b1", "b2", "b3"}[i%3], time.Second, err)
+	}
+	if n := strings.Count(buf.String(), "failed to send request, retry later"); n != 1 {
+		t.Fatalf("expected a single warning before the summary, got %d:\n%s", n, buf.String())
+	}
+
+	r.flush()
+	if !strings.Contains(buf.String(), "99 retries for 3 batches in last") || !strings.Contains(buf.String(), "last error: http code: 503") {
+		t.Fatalf("expected a summary, got:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	r.failed("b4", time.Second, err)
+	if !strings.Contains(buf.String(), "failed to send request, retry later") {
+		t.Fatalf("expected the first failure after a flush to be logged, got:\n%s", buf.String())
+	}
+	r.flush()
+}
+
+func TestRetryLogInterval(t *testing.T) {
+	var buf syncBuffer
+	r := newRetryLog(NewLogger(&buf, LevelWarn), 20*time.Millisecond)
+
+	err := errors.New("timeout")
+	r.failed("b1", time.Second, err)
+	r.failed("b1", time.Second, err)
+	time.Sleep(60 * time.Millisecond)
+	if !strings.Contains(buf.String(), "1 retries for 1 batches") {
+		t.Fatalf("expected a periodic summary, got:\n%s", buf.String())
+	}
+
+	r.mu.Lock()
+	idle := r.timer == nil
+	r.mu.Unlock()
+	if !idle {
+		t.Fatal("expected the interval to close after a quiet one")
+	}
+}
+
+func TestOutageLogsOnce(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html><body>502 Bad Gateway</body></html>"))
+	}))
+	defer srv.Close()
+
+	var buf syncBuffer
+	c, err := NewClient(Config{
+		Endpoint:                 srv.URL,
+		RetryTimeIntervalInitial: time.Millisecond,
+		RetryTimeIntervalMax:     time.Millisecond,
+		RetryLogInterval:         time.Hour,
+		Logger:                   NewLogger(&buf, LevelWarn),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c.Collect(ctx, &Messages{BatchId: "b-1", Messages: []Message{*newTestMessage(0)}})
+
+	// HTML error pages are part of the retry summary, not logged per attempt
+	if n := strings.Count(buf.String(), "\n"); n != 1 {
+		t.Fatalf("expected a single warning, got %d:\n%s", n, buf.String())
+	}
+	c.retryLog.flush()
+	if !strings.Contains(buf.String(), "last error: http code: 502: <html>") {
+		t.Fatalf("expected the response in the summary, got:\n%s", buf.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to read while a logger writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
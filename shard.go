@@ -0,0 +1,60 @@
+package client
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// shard is an independent queue and batching loop. Shards only share the
+// dispatcher their batches are handed to.
+type shard struct {
+	id       int
+	queue    *queue
+	flushCh  chan chan struct{}
+	limitsCh chan batchLimits
+	nextLane int // lane of the next message without partition key, owned by the batching loop
+}
+
+func (bc *BufferedClient) newShard(id int) *shard {
+	n := bc.conf.BatchingShards
+	s := &shard{
+		id:       id,
+		queue:    newQueue(splitLimit(bc.conf.MaxQueueMessages, n), splitLimit(bc.conf.MaxQueueBytes, n), bc.conf.OverflowPolicy),
+		flushCh:  make(chan chan struct{}),
+		limitsCh: make(chan batchLimits),
+	}
+	s.queue.onDrop = bc.dropEnvelope
+	s.queue.highItems = int(float64(s.queue.maxItems) * bc.conf.QueueHighWatermark)
+	s.queue.highBytes = int(float64(s.queue.maxBytes) * bc.conf.QueueHighWatermark)
+	s.queue.onWatermark = func(depth int, above bool) {
+		if above {
+			bc.conf.Logger.WithField("shard", id).WithField("depth", depth).Warn("queue reached its high watermark")
+		}
+		bc.events.emit(Event{Kind: EventQueueWatermark, Shard: id, QueueDepth: depth, Above: above})
+	}
+	return s
+}
+
+// splitLimit divides a queue limit among n shards, zero stays unlimited.
+func splitLimit(limit, n int) int {
+	return (limit + n - 1) / n
+}
+
+// shardOf picks the shard of m. Messages sharing a partition key always go
+// to the same shard, which keeps them in order.
+func (bc *BufferedClient) shardOf(m *Message) *shard {
+	n := len(bc.shards)
+	if n == 1 {
+		return bc.shards[0]
+	}
+
+	if bc.conf.PartitionKey != nil {
+		if key := bc.conf.PartitionKey(m); key != "" {
+			h := fnv.New32a()
+			h.Write([]byte(key))
+			// the low bits pick the lane, keep them independent
+			return bc.shards[(h.Sum32()>>16)%uint32(n)]
+		}
+	}
+	return bc.shards[atomic.AddUint32(&bc.nextShard, 1)%uint32(n)]
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardedBatching(t *testing.T) {
+	srv := newFakeIngest(t)
+	conf := testBufferedConfig(srv.URL)
+	conf.BatchingShards = 4
+	conf.MaxQueueMessages = 100
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bc.shards) != 4 || bc.shards[0].queue.maxItems != 25 {
+		t.Fatal("expected the queue to be split among 4 shards")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wg := sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if err := bc.Send(ctx, newTestMessage(g*50+i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if err := bc.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.received(); n != 400 {
+		t.Fatalf("expected 400 messages, got %d", n)
+	}
+	if err := bc.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkSendParallel(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+	}))
+	defer srv.Close()
+
+	for _, shards := range []int{1, runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			conf := testBufferedConfig(srv.URL)
+			conf.BatchingShards = shards
+			conf.MaxConcurrency = 32
+			conf.MaxDurationPerBatch = 50 * time.Millisecond
+			bc, err := NewBufferedClient(conf)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ctx := context.Background()
+			msg := newTestMessage(0)
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := bc.Send(ctx, msg); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.StopTimer()
+			bc.Close(ctx)
+		})
+	}
+}
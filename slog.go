@@ -0,0 +1,157 @@
+//go:build go1.21
+
+package client
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+)
+
+// SlogLevelTrace is the slog level LevelTrace maps to, below slog.LevelDebug.
+const SlogLevelTrace = slog.LevelDebug - 4
+
+// NewSlogLogger returns a Logger writing to l, WithField fields become
+// attributes. It implements LoggerV2.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (l slogLogger) WithField(name string, value interface{}) Logger {
+	return slogLogger{l.l.With(name, value)}
+}
+
+func (l slogLogger) WithFields(fields map[string]interface{}) Logger {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := make([]interface{}, 0, 2*len(fields))
+	for _, name := range names {
+		args = append(args, name, fields[name])
+	}
+	return slogLogger{l.l.With(args...)}
+}
+
+func (l slogLogger) Enabled(lvl LogLevel) bool {
+	return l.l.Enabled(context.Background(), slogLevelOf(lvl))
+}
+
+func (l slogLogger) Trace(msg string) { l.l.Log(context.Background(), SlogLevelTrace, msg) }
+func (l slogLogger) Debug(msg string) { l.l.Debug(msg) }
+func (l slogLogger) Info(msg string)  { l.l.Info(msg) }
+func (l slogLogger) Warn(msg string)  { l.l.Warn(msg) }
+func (l slogLogger) Error(msg string) { l.l.Error(msg) }
+
+// NewSlogHandler returns a slog.Handler writing to l, so that an application
+// can log through the same output as the SDK, for example one made by
+// NewLogger. Attributes become fields, prefixed by their groups, and slog
+// levels map to the closest LogLevel: below slog.LevelDebug is LevelTrace.
+func NewSlogHandler(l Logger) slog.Handler {
+	return &slogHandler{l: l}
+}
+
+type slogHandler struct {
+	l      Logger
+	prefix string // groups opened by WithGroup, each followed by a dot
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return logEnabled(h.l, logLevelOf(level))
+}
+
+func (h *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	l := h.l
+	r.Attrs(func(a slog.Attr) bool {
+		l = withAttr(l, h.prefix, a)
+		return true
+	})
+
+	msg := r.Message
+	switch logLevelOf(r.Level) {
+	case LevelTrace:
+		l.Trace(msg)
+	case LevelDebug:
+		l.Debug(msg)
+	case LevelInfo:
+		l.Info(msg)
+	case LevelWarn:
+		l.Warn(msg)
+	default:
+		l.Error(msg)
+	}
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	l := h.l
+	for _, a := range attrs {
+		l = withAttr(l, h.prefix, a)
+	}
+	return &slogHandler{l: l, prefix: h.prefix}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{l: h.l, prefix: h.prefix + name + "."}
+}
+
+// withAttr adds a as a field of l, flattening groups into dotted names.
+func withAttr(l Logger, prefix string, a slog.Attr) Logger {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			l = withAttr(l, prefix, ga)
+		}
+		return l
+	}
+	if a.Key == "" {
+		return l
+	}
+	value := v.Any()
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	return l.WithField(prefix+a.Key, value)
+}
+
+func slogLevelOf(lvl LogLevel) slog.Level {
+	switch {
+	case lvl >= LevelTrace:
+		return SlogLevelTrace
+	case lvl >= LevelDebug:
+		return slog.LevelDebug
+	case lvl >= LevelInfo:
+		return slog.LevelInfo
+	case lvl >= LevelWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+func logLevelOf(level slog.Level) LogLevel {
+	switch {
+	case level < slog.LevelDebug:
+		return LevelTrace
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
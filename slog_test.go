@@ -0,0 +1,38 @@
+//go:build go1.21
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: SlogLevelTrace})))
+	l.WithField("batchId", "b1").Trace("sending batch")
+
+	if out := buf.String(); !strings.Contains(out, "level=DEBUG-4") || !strings.Contains(out, "batchId=b1") {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestSlogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewSlogHandler(NewLogger(&buf, LevelInfo))
+	if h.Enabled(context.Background(), slog.LevelDebug) {
+		t.Fatal("expected debug to be disabled")
+	}
+
+	slog.New(h).With("client", "game").WithGroup("req").Warn("request failed", "status", 503, "err", errors.New("boom"))
+	out := buf.String()
+	for _, s := range []string{"WARN request failed", `"client":"game"`, `"req.status":503`, `"req.err":"boom"`} {
+		if !strings.Contains(out, s) {
+			t.Fatalf("expected %s in %q", s, out)
+		}
+	}
+}
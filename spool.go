@@ -0,0 +1,428 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpoolFsyncPolicy decides when writes to the spool are flushed to stable
+// storage.
+type SpoolFsyncPolicy int
+
+const (
+	SpoolFsyncAlways   SpoolFsyncPolicy = iota // fsync after every batch written
+	SpoolFsyncInterval                         // fsync at most once per SpoolFsyncInterval
+	SpoolFsyncNever                            // leave flushing to the operating system
+)
+
+const (
+	spoolSegmentExt    = ".seg"
+	spoolRecordHdrSize = 8  // payload length + crc32c of payload
+	spoolRecordMetaLen = 14 // encoding, priority, lane, number of lanes and of expiries
+)
+
+var (
+	errSpoolFull = errors.New("spool is full")
+
+	spoolCrcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// spool is a write-ahead log of batches on disk. Batches are appended to
+// segment files as soon as they are sealed, a segment is removed once every batch in
+// it has been acknowledged.
+//
+// A record is laid out as:
+//
+//	| length uint32 | crc32c uint32 | encoding byte | priority int8 | lane int32 | lanes uint32 |
+//	| expiries uint32 | expiry int64 unix nanoseconds * expiries | encoded Messages |
+//
+// where length and crc32c cover everything after the header. The priority
+// and the partition lane are restored on recovery so that batches keep their
+// class and per-key order across restarts; the lane is only kept when the
+// number of lanes did not change. Expiries are those of the messages, zero
+// when one never expires, or none when no message of the batch does; the
+// messages expired by the time of the recovery are dropped.
+type spool struct {
+	dir           string
+	encoding      string
+	segmentBytes  int64
+	maxBytes      int64
+	fsync         SpoolFsyncPolicy
+	fsyncInterval time.Duration
+	lanes         int // partition lanes of the client across priorities, 0 when not partitioned
+	logger        Logger
+
+	mu        sync.Mutex
+	active    *segment
+	nextSeq   int64
+	totalSize int64
+	lastSync  time.Time
+}
+
+// segment is a single spool file.
+type segment struct {
+	seq     int64
+	path    string
+	size    int64
+	pending int // records not acknowledged yet
+	file    *os.File
+}
+
+func openSpool(conf BufferedClientConfig, encoding string) (*spool, []*batch, error) {
+	if err := os.MkdirAll(conf.SpoolDir, 0o755); err != nil {
+		return nil, nil, fmt.Errorf("create spool dir: %w", err)
+	}
+
+	s := &spool{
+		dir:           conf.SpoolDir,
+		encoding:      encoding,
+		segmentBytes:  conf.SpoolSegmentBytes,
+		maxBytes:      conf.SpoolMaxBytes,
+		fsync:         conf.SpoolFsync,
+		fsyncInterval: conf.SpoolFsyncInterval,
+		logger:        conf.Logger,
+	}
+	if conf.PartitionKey != nil {
+		s.lanes = numPriorities * conf.Partitions
+	}
+
+	batches, err := s.recover()
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, batches, nil
+}
+
+// recover loads the batches left in the spool by a previous run.
+func (s *spool) recover() ([]*batch, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool dir: %w", err)
+	}
+
+	var segs []*segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segs = append(segs, &segment{seq: seq, path: filepath.Join(s.dir, name)})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].seq < segs[j].seq })
+
+	var (
+		batches []*batch
+		relaned int
+		expired int
+	)
+	now := time.Now()
+	for _, seg := range segs {
+		if seg.seq >= s.nextSeq {
+			s.nextSeq = seg.seq + 1
+		}
+
+		recs, size, err := s.readSegment(seg.path)
+		if err != nil {
+			s.logger.WithField("segment", seg.path).WithField("err", err.Error()).Warn("spool segment is damaged, keeping readable records only")
+		}
+		kept := recs[:0]
+		for _, rec := range recs {
+			expired += rec.expire(now)
+			if rec.len() > 0 {
+				kept = append(kept, rec)
+			}
+		}
+		if len(kept) == 0 {
+			os.Remove(seg.path)
+			continue
+		}
+
+		seg.size = size
+		seg.pending = len(kept)
+		s.totalSize += size
+		for _, rec := range kept {
+			b := rec.batch
+			if b.lane != noLane && (rec.lanes != s.lanes || b.lane < 0 || b.lane >= s.lanes) {
+				b.lane = noLane
+				relaned++
+			}
+			b.spooled = seg
+			b.createdAt = now
+			batches = append(batches, b)
+		}
+	}
+
+	if expired > 0 {
+		s.logger.WithField("expired", expired).Warn("dropped expired messages from spool")
+	}
+	if relaned > 0 {
+		s.logger.WithField("batches", relaned).Warn("number of partitions changed, recovered batches lost their partition order")
+	}
+	if len(batches) > 0 {
+		s.logger.WithField("batches", len(batches)).Info("recovered batches from spool")
+	}
+	return batches, nil
+}
+
+// spoolRecord is a batch read from the spool with the number of lanes of the
+// client that wrote it.
+type spoolRecord struct {
+	*batch
+	lanes int
+}
+
+// expire drops the messages past their expiry, it returns how many were.
+func (rec spoolRecord) expire(now time.Time) int {
+	b := rec.batch
+	if len(b.expires) == 0 {
+		return 0
+	}
+	n := 0
+	for i := range b.expires {
+		if b.expires[i].IsZero() || !now.After(b.expires[i]) {
+			b.Messages.Messages[n] = b.Messages.Messages[i]
+			b.expires[n] = b.expires[i]
+			n++
+		}
+	}
+	dropped := len(b.expires) - n
+	b.Messages.Messages = b.Messages.Messages[:n]
+	b.expires = b.expires[:n]
+	// prune walks these along with expires
+	b.futures = make([]*SendFuture, n)
+	b.sizes = make([]int, n)
+	return dropped
+}
+
+// readSegment reads the records of a segment up to the first damaged one.
+func (s *spool) readSegment(path string) ([]spoolRecord, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		recs []spoolRecord
+		off  int64
+	)
+	for int64(len(data))-off > 0 {
+		rest := data[off:]
+		if len(rest) < spoolRecordHdrSize {
+			return recs, off, io.ErrUnexpectedEOF
+		}
+		n := int64(binary.BigEndian.Uint32(rest[0:4]))
+		sum := binary.BigEndian.Uint32(rest[4:8])
+		if int64(len(rest))-spoolRecordHdrSize < n || n < spoolRecordMetaLen {
+			return recs, off, io.ErrUnexpectedEOF
+		}
+		payload := rest[spoolRecordHdrSize : spoolRecordHdrSize+n]
+		if crc32.Checksum(payload, spoolCrcTable) != sum {
+			return recs, off, fmt.Errorf("checksum mismatch at offset %d", off)
+		}
+
+		expiries := int64(binary.BigEndian.Uint32(payload[10:14]))
+		encoded := payload[spoolRecordMetaLen:]
+		if int64(len(encoded)) < 8*expiries {
+			return recs, off, fmt.Errorf("truncated expiries at offset %d", off)
+		}
+		b := &batch{prio: Priority(int8(payload[1])), lane: int(int32(binary.BigEndian.Uint32(payload[2:6])))}
+		if !b.prio.valid() {
+			b.prio = PriorityNormal
+		}
+		for i := int64(0); i < expiries; i++ {
+			var at time.Time
+			if ns := int64(binary.BigEndian.Uint64(encoded[8*i:])); ns != 0 {
+				at = time.Unix(0, ns)
+			}
+			b.expires = append(b.expires, at)
+		}
+		encoded = encoded[8*expiries:]
+
+		b.Messages = &Messages{}
+		if err := decoding(spoolEncodings[payload[0]], encoded, b.Messages); err != nil {
+			return recs, off, fmt.Errorf("decode record at offset %d: %w", off, err)
+		}
+		if len(b.expires) != 0 && len(b.expires) != len(b.Messages.Messages) {
+			return recs, off, fmt.Errorf("%d expiries for %d messages at offset %d", len(b.expires), len(b.Messages.Messages), off)
+		}
+		recs = append(recs, spoolRecord{batch: b, lanes: int(binary.BigEndian.Uint32(payload[6:10]))})
+		off += spoolRecordHdrSize + n
+	}
+	return recs, off, nil
+}
+
+var spoolEncodings = map[byte]string{'j': "json", 'm': "msgpack"}
+
+// write appends b to the active segment, and remembers the segment in b so
+// that it can be acknowledged later.
+func (s *spool) write(b *batch) error {
+	data, err := encoding(s.encoding, b.Messages)
+	if err != nil {
+		return err
+	}
+
+	var expiries []time.Time
+	for _, at := range b.expires {
+		if !at.IsZero() {
+			expiries = b.expires
+			break
+		}
+	}
+
+	rec := make([]byte, spoolRecordHdrSize+spoolRecordMetaLen+8*len(expiries)+len(data))
+	meta := rec[spoolRecordHdrSize:]
+	meta[0] = s.encoding[0]
+	meta[1] = byte(int8(b.prio))
+	binary.BigEndian.PutUint32(meta[2:6], uint32(int32(b.lane)))
+	binary.BigEndian.PutUint32(meta[6:10], uint32(s.lanes))
+	binary.BigEndian.PutUint32(meta[10:14], uint32(len(expiries)))
+	body := meta[spoolRecordMetaLen:]
+	for i, at := range expiries {
+		if !at.IsZero() {
+			binary.BigEndian.PutUint64(body[8*i:], uint64(at.UnixNano()))
+		}
+	}
+	copy(body[8*len(expiries):], data)
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(rec)-spoolRecordHdrSize))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.Checksum(rec[spoolRecordHdrSize:], spoolCrcTable))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxBytes > 0 && s.totalSize+int64(len(rec)) > s.maxBytes {
+		return errSpoolFull
+	}
+
+	if s.active == nil || s.active.size >= s.segmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	seg := s.active
+	if _, err := seg.file.Write(rec); err != nil {
+		s.retire(seg)
+		return fmt.Errorf("write spool segment: %w", err)
+	}
+
+	switch s.fsync {
+	case SpoolFsyncAlways:
+		err = seg.file.Sync()
+	case SpoolFsyncInterval:
+		if time.Since(s.lastSync) >= s.fsyncInterval {
+			err = seg.file.Sync()
+			s.lastSync = time.Now()
+		}
+	}
+	if err != nil {
+		s.retire(seg)
+		return fmt.Errorf("sync spool segment: %w", err)
+	}
+
+	seg.size += int64(len(rec))
+	seg.pending++
+	s.totalSize += int64(len(rec))
+	b.spooled = seg
+	return nil
+}
+
+// retire stops writing to the active segment seg after a failed write, so
+// that a torn record is never followed by records recovery would not reach.
+// What was written of the record is cut off, the segment is then removed once
+// its batches are acknowledged, or right away when it has none. Caller must
+// hold mu.
+func (s *spool) retire(seg *segment) {
+	if err := seg.file.Truncate(seg.size); err != nil {
+		s.logger.WithField("segment", seg.path).WithField("err", err.Error()).Warn("unable to truncate spool segment after a failed write")
+	}
+	s.active = nil
+	s.closeSegment(seg)
+	if seg.pending == 0 {
+		os.Remove(seg.path)
+		s.totalSize -= seg.size
+	}
+}
+
+// rotate closes the active segment and opens a new one. Caller must hold mu.
+func (s *spool) rotate() error {
+	if s.active != nil {
+		s.closeSegment(s.active)
+		s.active = nil
+	}
+
+	seg := &segment{seq: s.nextSeq}
+	seg.path = filepath.Join(s.dir, fmt.Sprintf("%020d%s", seg.seq, spoolSegmentExt))
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("create spool segment: %w", err)
+	}
+	s.nextSeq++
+	seg.file = f
+	s.active = seg
+
+	if s.fsync == SpoolFsyncAlways {
+		if dir, err := os.Open(s.dir); err == nil {
+			dir.Sync()
+			dir.Close()
+		}
+	}
+	return nil
+}
+
+func (s *spool) closeSegment(seg *segment) {
+	if seg.file == nil {
+		return
+	}
+	if s.fsync != SpoolFsyncNever {
+		seg.file.Sync()
+	}
+	seg.file.Close()
+	seg.file = nil
+}
+
+// ack marks a batch written to seg as done, removing the segment once all of
+// its batches are done.
+func (s *spool) ack(seg *segment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seg.pending--
+	if seg.pending > 0 {
+		return
+	}
+
+	if seg == s.active {
+		s.active = nil
+	}
+	s.closeSegment(seg)
+	if err := os.Remove(seg.path); err != nil {
+		s.logger.WithField("segment", seg.path).WithField("err", err.Error()).Warn("unable to remove spool segment")
+	}
+	s.totalSize -= seg.size
+}
+
+// close flushes and closes the active segment, unacknowledged batches stay on
+// disk to be replayed by the next client using the same directory.
+func (s *spool) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil {
+		s.closeSegment(s.active)
+		s.active = nil
+	}
+}
@@ -0,0 +1,272 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSpoolConfig(dir string) BufferedClientConfig {
+	return BufferedClientConfig{
+		SpoolDir:          dir,
+		SpoolSegmentBytes: 4 << 20,
+		SpoolFsync:        SpoolFsyncAlways,
+		Logger:            NewLogger(io.Discard, LevelError),
+	}
+}
+
+func spoolSegments(t *testing.T, dir string) []string {
+	segs, err := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return segs
+}
+
+func TestSpoolRecover(t *testing.T) {
+	dir := t.TempDir()
+
+	s, recovered, err := openSpool(testSpoolConfig(dir), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 0 {
+		t.Fatalf("expected empty spool, got %d batches", len(recovered))
+	}
+
+	for _, id := range []string{"b-1", "b-2"} {
+		b := &batch{Messages: &Messages{BatchId: id, Messages: []Message{{
+			Type: "Event",
+			Data: map[string]interface{}{"#user_id": int64(1) << 60},
+		}}}}
+		if err := s.write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.close()
+
+	// a torn write at the tail must not hide the records before it
+	segs := spoolSegments(t, dir)
+	f, err := os.OpenFile(segs[len(segs)-1], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 42})
+	f.Close()
+
+	s, recovered, err = openSpool(testSpoolConfig(dir), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 2 || recovered[0].BatchId != "b-1" || recovered[1].BatchId != "b-2" {
+		t.Fatalf("unexpected recovered batches %+v", recovered)
+	}
+	data := recovered[0].Messages.Messages[0].Data.(map[string]interface{})
+	if data["#user_id"] != json.Number("1152921504606846976") {
+		t.Fatalf("integer was not preserved: %v", data["#user_id"])
+	}
+
+	for _, b := range recovered {
+		s.ack(b.spooled)
+	}
+	if segs := spoolSegments(t, dir); len(segs) != 0 {
+		t.Fatalf("expected acknowledged segments to be removed, got %v", segs)
+	}
+}
+
+func TestSpoolKeepsPriorityAndLane(t *testing.T) {
+	dir := t.TempDir()
+	conf := testSpoolConfig(dir)
+	conf.PartitionKey = PartitionByField("#user_id")
+	conf.Partitions = 4
+
+	s, _, err := openSpool(conf, "msgpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &batch{Messages: &Messages{BatchId: "b-1", Messages: []Message{*newTestMessage(0)}}, prio: PriorityHigh, lane: 9}
+	if err := s.write(b); err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+
+	s, recovered, err := openSpool(conf, "msgpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+	if len(recovered) != 1 || recovered[0].prio != PriorityHigh || recovered[0].lane != 9 {
+		t.Fatalf("expected priority and lane to be restored, got %+v", recovered)
+	}
+
+	// lanes are hashed by key among Partitions, they mean nothing once it changes
+	conf.Partitions = 8
+	_, recovered, err = openSpool(conf, "msgpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 1 || recovered[0].prio != PriorityHigh || recovered[0].lane != noLane {
+		t.Fatalf("expected priority to be restored without lane, got %+v", recovered)
+	}
+}
+
+func TestSpoolWriteFailure(t *testing.T) {
+	dir := t.TempDir()
+	s, _, err := openSpool(testSpoolConfig(dir), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newBatch := func(id string) *batch {
+		return &batch{Messages: &Messages{BatchId: id, Messages: []Message{*newTestMessage(0)}}}
+	}
+	if err := s.write(newBatch("b-1")); err != nil {
+		t.Fatal(err)
+	}
+	size := s.totalSize
+
+	// a torn record the failed write could not cut off
+	seg := s.active
+	f, err := os.OpenFile(seg.path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 42})
+	f.Close()
+	seg.file.Close()
+	if seg.file, err = os.Open(seg.path); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := newBatch("b-2")
+	if err := s.write(failed); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if failed.spooled != nil || s.totalSize != size || seg.pending != 1 || s.active != nil {
+		t.Fatalf("failed write was accounted: spooled %v, size %d, pending %d", failed.spooled, s.totalSize, seg.pending)
+	}
+
+	// the following records are not lost behind the torn one
+	if err := s.write(newBatch("b-3")); err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+	_, recovered, err := openSpool(testSpoolConfig(dir), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 2 || recovered[0].BatchId != "b-1" || recovered[1].BatchId != "b-3" {
+		t.Fatalf("unexpected recovered batches %+v", recovered)
+	}
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	conf := testSpoolConfig(t.TempDir())
+	conf.SpoolMaxBytes = 100
+
+	s, _, err := openSpool(conf, "msgpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	b := &batch{Messages: &Messages{BatchId: "b-1", Messages: []Message{*newTestMessage(0)}}}
+	for i := 0; i < 10; i++ {
+		if err = s.write(b); err != nil {
+			break
+		}
+	}
+	if err != errSpoolFull {
+		t.Fatalf("expected errSpoolFull, got %v", err)
+	}
+}
+
+func TestBufferedClientReplaysSpool(t *testing.T) {
+	srv := newFakeIngest(t)
+	dir := t.TempDir()
+
+	s, _, err := openSpool(testSpoolConfig(dir), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &batch{Messages: &Messages{BatchId: "b-crashed", Messages: []Message{*newTestMessage(0), *newTestMessage(1)}}}
+	if err := s.write(b); err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+
+	conf := testBufferedConfig(srv.URL)
+	conf.SpoolDir = dir
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bc.SendAndWait(ctx, newTestMessage(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := srv.received(); n != 3 {
+		t.Fatalf("expected 3 messages delivered, got %d", n)
+	}
+	if segs := spoolSegments(t, dir); len(segs) != 0 {
+		t.Fatalf("expected spool to be empty, got %v", segs)
+	}
+}
+
+func TestBufferedClientSpoolsSealedBatches(t *testing.T) {
+	srv := newFakeIngest(t)
+	gate := make(chan struct{})
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		<-gate
+		srv.record(b)
+	})
+	dir := t.TempDir()
+
+	conf := testBufferedConfig(srv.URL)
+	conf.SpoolDir = dir
+	conf.MaxMessagesPerBatch = 1
+	conf.MaxConcurrency = 1
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close(context.Background())
+	defer close(gate)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if err := bc.Send(ctx, newTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for bc.Stats().PendingBatches != 3 {
+		if ctx.Err() != nil {
+			t.Fatal("batches not sealed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// one batch is in flight, the others wait for a request slot
+	records := 0
+	for _, seg := range spoolSegments(t, dir) {
+		recs, _, err := bc.spool.readSegment(seg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records += len(recs)
+	}
+	if records != 3 {
+		t.Fatalf("expected every sealed batch to be spooled, got %d records", records)
+	}
+}
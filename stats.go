@@ -0,0 +1,276 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBounds are the upper bounds of the latency histogram buckets.
+var latencyBounds = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// batchSizeBounds are the upper bounds of the batch size histogram buckets.
+var batchSizeBounds = []int{1, 10, 50, 100, 250, 500, 1000, 2000, 5000}
+
+// Stats is a snapshot of the counters and gauges of a client. Counters only
+// grow over the life of the client.
+type Stats struct {
+	MessagesSent    uint64 // messages delivered
+	MessagesFailed  uint64 // messages that failed permanently
+	MessagesDropped uint64 // messages expired or shed, see BufferedClient.Dropped
+	BatchesSent     uint64
+	BatchesFailed   uint64
+
+	Requests         uint64         // requests made, including retries
+	Retries          uint64         // requests beyond the first of a batch
+	RequestsByStatus map[int]uint64 // by HTTP status code, 0 for requests without response
+	BytesRaw         uint64         // encoded bytes before compression
+	BytesCompressed  uint64         // bytes of request bodies, after compression
+
+	BatchesInFlight int // requests waiting for a response
+
+	// Gauges only reported by BufferedClient.
+	QueuedMessages   int   // messages waiting to be batched
+	PendingBatches   int   // sealed batches not resolved yet, including those in flight and waiting for a retry
+	RetryingBatches  int   // batches waiting for their next attempt
+	BufferedBytes    int64 // see MaxBufferedBytes, only counted when message sizes are computed
+	ConcurrencyLimit int
+
+	LastError     string
+	LastErrorTime time.Time
+
+	Latency    map[string]LatencyHistogram // request latency per endpoint
+	BatchSizes BatchSizeHistogram          // messages per prepared batch
+	RateLimits []RateLimitStat
+}
+
+// LatencyHistogram counts requests by latency.
+type LatencyHistogram struct {
+	Bounds []time.Duration // upper bound of each bucket but the last, which is unbounded
+	Counts []uint64        // requests per bucket, one more than Bounds
+	Count  uint64
+	Sum    time.Duration
+}
+
+func (h *LatencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(h.Bounds) && d > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+// BatchSizeHistogram counts batches by number of messages.
+type BatchSizeHistogram struct {
+	Bounds []int    // upper bound of each bucket but the last, which is unbounded
+	Counts []uint64 // batches per bucket, one more than Bounds
+	Count  uint64
+	Sum    uint64
+}
+
+func (h *BatchSizeHistogram) observe(n int) {
+	i := 0
+	for i < len(h.Bounds) && n > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += uint64(n)
+}
+
+// metrics holds the counters behind Stats.
+type metrics struct {
+	// accessed atomically, kept first for 64-bit alignment
+	messagesSent    uint64
+	messagesFailed  uint64
+	batchesSent     uint64
+	batchesFailed   uint64
+	requests        uint64
+	retries         uint64
+	bytesRaw        uint64
+	bytesCompressed uint64
+	inflight        int64
+
+	mu        sync.Mutex
+	statuses  map[int]uint64
+	latency   map[string]*LatencyHistogram
+	sizes     BatchSizeHistogram
+	lastErr   string
+	lastErrAt time.Time
+	recent    []RequestError // ring of the last maxRecentErrors errors
+	nextErr   int
+}
+
+// maxRecentErrors is the number of request errors kept for debugging.
+const maxRecentErrors = 20
+
+// RequestError describes a failed request.
+type RequestError struct {
+	Time       time.Time
+	Endpoint   string
+	BatchId    string
+	StatusCode int // 0 when no response was received
+	Error      string
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		statuses: make(map[int]uint64),
+		latency:  make(map[string]*LatencyHistogram),
+		sizes:    BatchSizeHistogram{Bounds: batchSizeBounds, Counts: make([]uint64, len(batchSizeBounds)+1)},
+	}
+}
+
+func (m *metrics) delivered(messages int) {
+	atomic.AddUint64(&m.messagesSent, uint64(messages))
+	atomic.AddUint64(&m.batchesSent, 1)
+}
+
+func (m *metrics) failed(messages int) {
+	atomic.AddUint64(&m.messagesFailed, uint64(messages))
+	atomic.AddUint64(&m.batchesFailed, 1)
+}
+
+func (m *metrics) prepared(messages, raw, compressed int) {
+	atomic.AddUint64(&m.bytesRaw, uint64(raw))
+	atomic.AddUint64(&m.bytesCompressed, uint64(compressed))
+
+	m.mu.Lock()
+	m.sizes.observe(messages)
+	m.mu.Unlock()
+}
+
+func (m *metrics) retried() {
+	atomic.AddUint64(&m.retries, 1)
+}
+
+// request records a request of batchID to endpoint that took latency and
+// ended with err.
+func (m *metrics) request(endpoint, batchID string, latency time.Duration, err error) {
+	atomic.AddUint64(&m.requests, 1)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.statuses[statusCode(err)]++
+	h, ok := m.latency[endpoint]
+	if !ok {
+		h = &LatencyHistogram{Bounds: latencyBounds, Counts: make([]uint64, len(latencyBounds)+1)}
+		m.latency[endpoint] = h
+	}
+	h.observe(latency)
+	if err != nil {
+		m.lastErr = err.Error()
+		m.lastErrAt = time.Now()
+
+		re := RequestError{Time: m.lastErrAt, Endpoint: endpoint, BatchId: batchID, StatusCode: statusCode(err), Error: m.lastErr}
+		if len(m.recent) < maxRecentErrors {
+			m.recent = append(m.recent, re)
+		} else {
+			m.recent[m.nextErr] = re
+		}
+		m.nextErr = (m.nextErr + 1) % maxRecentErrors
+	}
+}
+
+// recentErrors returns the last request errors, most recent first.
+func (m *metrics) recentErrors() []RequestError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	errs := make([]RequestError, 0, len(m.recent))
+	for i := 1; i <= len(m.recent); i++ {
+		errs = append(errs, m.recent[(m.nextErr-i+maxRecentErrors)%maxRecentErrors])
+	}
+	return errs
+}
+
+// statusCode returns the HTTP status of a request that ended with err, 0
+// when no response was received.
+func statusCode(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	var ierr Error
+	if errors.As(err, &ierr) {
+		return ierr.StatusCode
+	}
+	return 0
+}
+
+func (m *metrics) snapshot() Stats {
+	s := Stats{
+		MessagesSent:    atomic.LoadUint64(&m.messagesSent),
+		MessagesFailed:  atomic.LoadUint64(&m.messagesFailed),
+		BatchesSent:     atomic.LoadUint64(&m.batchesSent),
+		BatchesFailed:   atomic.LoadUint64(&m.batchesFailed),
+		Requests:        atomic.LoadUint64(&m.requests),
+		Retries:         atomic.LoadUint64(&m.retries),
+		BytesRaw:        atomic.LoadUint64(&m.bytesRaw),
+		BytesCompressed: atomic.LoadUint64(&m.bytesCompressed),
+		BatchesInFlight: int(atomic.LoadInt64(&m.inflight)),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s.RequestsByStatus = make(map[int]uint64, len(m.statuses))
+	for status, n := range m.statuses {
+		s.RequestsByStatus[status] = n
+	}
+	s.Latency = make(map[string]LatencyHistogram, len(m.latency))
+	for endpoint, h := range m.latency {
+		c := *h
+		c.Counts = append([]uint64(nil), h.Counts...)
+		s.Latency[endpoint] = c
+	}
+	s.BatchSizes = m.sizes
+	s.BatchSizes.Counts = append([]uint64(nil), m.sizes.Counts...)
+	s.LastError = m.lastErr
+	s.LastErrorTime = m.lastErrAt
+	return s
+}
+
+// Stats returns a snapshot of the counters of the client. It is safe to call
+// concurrently and cheap enough to poll.
+func (c *Client) Stats() Stats {
+	s := c.metrics.snapshot()
+	s.RateLimits = c.limiter.stats()
+	return s
+}
+
+// Stats returns a snapshot of the counters and gauges of the client. It is
+// safe to call concurrently and cheap enough to poll.
+func (bc *BufferedClient) Stats() Stats {
+	s := bc.client.metrics.snapshot()
+
+	d := bc.Dropped()
+	s.MessagesDropped = d.Expired + d.Shed
+	for _, sh := range bc.shards {
+		s.QueuedMessages += sh.queue.len()
+	}
+	bc.pendingMu.Lock()
+	s.PendingBatches = len(bc.pending)
+	bc.pendingMu.Unlock()
+	s.RetryingBatches = bc.retries.len()
+	s.BufferedBytes = atomic.LoadInt64(&bc.buffered)
+	s.ConcurrencyLimit = bc.limiter.current()
+	s.RateLimits = bc.rateLimiter.stats()
+	return s
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	srv := newFakeIngest(t)
+	var requests int64
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		if atomic.AddInt64(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		srv.record(b)
+	})
+
+	bc, err := NewBufferedClient(testBufferedConfig(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := bc.SendAndWait(ctx, newTestMessage(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := bc.Stats()
+	if s.MessagesSent != 1 || s.BatchesSent != 1 || s.Requests != 2 || s.Retries != 1 {
+		t.Fatalf("unexpected counters: %+v", s)
+	}
+	if s.RequestsByStatus[http.StatusServiceUnavailable] != 1 || s.RequestsByStatus[http.StatusOK] != 1 {
+		t.Fatalf("unexpected requests by status: %v", s.RequestsByStatus)
+	}
+	if h := s.Latency[srv.URL]; h.Count != 2 || len(h.Counts) != len(h.Bounds)+1 {
+		t.Fatalf("unexpected latency histogram: %+v", h)
+	}
+	if s.BatchSizes.Count != 1 || s.BatchSizes.Counts[0] != 1 {
+		t.Fatalf("unexpected batch size histogram: %+v", s.BatchSizes)
+	}
+	if s.LastError == "" || s.BytesRaw == 0 || s.BytesCompressed == 0 {
+		t.Fatalf("expected last error and bytes to be reported: %+v", s)
+	}
+	if s.PendingBatches != 0 || s.BatchesInFlight != 0 || s.QueuedMessages != 0 {
+		t.Fatalf("expected no pending work: %+v", s)
+	}
+}
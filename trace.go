@@ -0,0 +1,35 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer instruments the batches sent by a client, see the ingestotel
+// package. It must be safe for concurrent use.
+type Tracer interface {
+	// StartBatch is called once per Collect call, or per batch of a
+	// BufferedClient, before its first request. The returned context is
+	// passed to StartAttempt, end is called with the final outcome.
+	StartBatch(ctx context.Context, batch BatchInfo) (context.Context, func(err error))
+
+	// StartAttempt is called before each request of a batch. Headers such as
+	// traceparent may be added to header. end is called with the status code
+	// of the response, 0 when there was none.
+	StartAttempt(ctx context.Context, attempt AttemptInfo, header http.Header) (context.Context, func(statusCode int, err error))
+}
+
+// BatchInfo describes a batch to a Tracer.
+type BatchInfo struct {
+	BatchId         string
+	Messages        int
+	EncodedBytes    int // before compression
+	CompressedBytes int // request body
+}
+
+// AttemptInfo describes a request to a Tracer.
+type AttemptInfo struct {
+	BatchInfo
+	Attempt  int // 1 for the first request of the batch
+	Endpoint string
+}
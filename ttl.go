@@ -0,0 +1,169 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrExpired resolves the future of a message dropped because it was not
+// delivered within its max age.
+var ErrExpired = errors.New("message expired")
+
+// WithTTL drops the message instead of sending it once it is older than ttl,
+// taking precedence over TypeMaxAges and MaxMessageAge. Zero means never.
+func WithTTL(ttl time.Duration) SendOption {
+	return func(o *sendOptions) {
+		o.ttl = ttl
+		o.hasTTL = true
+	}
+}
+
+// DropStats counts the messages a BufferedClient dropped instead of sending.
+type DropStats struct {
+	Expired uint64 // older than their max age
+	Shed    uint64 // dropped by the OverflowPolicy or to stay within MaxBufferedBytes
+}
+
+// Dropped reports the messages dropped so far.
+func (bc *BufferedClient) Dropped() DropStats {
+	return DropStats{
+		Expired: atomic.LoadUint64(&bc.expired),
+		Shed:    atomic.LoadUint64(&bc.shed),
+	}
+}
+
+// ttlOf resolves the max age of m sent with opts, zero means never.
+func (bc *BufferedClient) ttlOf(m *Message, opts sendOptions) time.Duration {
+	if opts.hasTTL {
+		return opts.ttl
+	}
+	if ttl, ok := bc.conf.TypeMaxAges[m.Type]; ok {
+		return ttl
+	}
+	return bc.conf.MaxMessageAge
+}
+
+func (e *envelope) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// expire drops a queued message past its max age.
+func (bc *BufferedClient) expire(e *envelope) {
+	atomic.AddUint64(&bc.expired, 1)
+	atomic.AddInt64(&bc.buffered, -int64(e.size))
+	e.drop(ErrExpired)
+}
+
+// prune drops the messages of b past their max age before an attempt. It
+// returns true when nothing is left, the batch is then settled. What is left
+// is encoded again. The spool record of b keeps the expired messages, they
+// are dropped again when it is recovered.
+func (bc *BufferedClient) prune(b *batch) bool {
+	now := time.Now()
+	n := 0
+	for i := range b.expires {
+		if b.expires[i].IsZero() || !now.After(b.expires[i]) {
+			b.Messages.Messages[n] = b.Messages.Messages[i]
+			b.futures[n] = b.futures[i]
+			b.expires[n] = b.expires[i]
+			b.sizes[n] = b.sizes[i]
+			n++
+			continue
+		}
+		// counted before the future is resolved, like in expire
+		atomic.AddUint64(&bc.expired, 1)
+		if b.futures[i] != nil {
+			b.futures[i].resolve(ErrExpired)
+		}
+		b.bytes -= b.sizes[i]
+		atomic.AddInt64(&bc.buffered, -int64(b.sizes[i]))
+	}
+	if n == len(b.expires) {
+		return false
+	}
+
+	bc.conf.Logger.WithField("batchId", b.BatchId).WithField("expired", len(b.expires)-n).Warn("dropped expired messages")
+	bc.pendingMu.Lock()
+	b.Messages.Messages = b.Messages.Messages[:n]
+	bc.pendingMu.Unlock()
+	b.futures = b.futures[:n]
+	b.expires = b.expires[:n]
+	b.sizes = b.sizes[:n]
+
+	if n == 0 {
+		if b.spooled != nil {
+			bc.spool.ack(b.spooled)
+		}
+		bc.finish(b, ErrExpired)
+		return true
+	}
+
+	if b.data != nil {
+		data, raw, err := bc.client.encode(b.Messages)
+		if err != nil {
+			bc.fail(b, err)
+			return true
+		}
+		b.data, b.raw = data, raw
+	}
+	return false
+}
+
+// reserve accounts e in the memory budget, shedding the oldest buffered
+// messages of the lowest priority up to e's when it is exceeded: batches
+// waiting for a retry first, then queued messages. e itself is dropped when
+// nothing else can be.
+func (bc *BufferedClient) reserve(e *envelope) error {
+	max := bc.conf.MaxBufferedBytes
+	if max > 0 && int64(e.size) > max {
+		return fmt.Errorf("message of %d bytes exceeds MaxBufferedBytes of %d bytes", e.size, max)
+	}
+
+	total := atomic.AddInt64(&bc.buffered, int64(e.size))
+	for max > 0 && total > max {
+		if b := bc.retries.shed(e.prio); b != nil {
+			bc.shedBatch(b)
+		} else if !bc.evict(e.prio) {
+			atomic.AddInt64(&bc.buffered, -int64(e.size))
+			atomic.AddUint64(&bc.shed, 1)
+			return ErrDropped
+		}
+		total = atomic.LoadInt64(&bc.buffered)
+	}
+	return nil
+}
+
+// evict drops a queued message of priority up to p from any shard.
+func (bc *BufferedClient) evict(p Priority) bool {
+	for _, s := range bc.shards {
+		if s.queue.evict(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// unreserve gives back the budget of a message that was not queued.
+func (bc *BufferedClient) unreserve(e *envelope) {
+	atomic.AddInt64(&bc.buffered, -int64(e.size))
+}
+
+// dropEnvelope settles a queued message dropped by the queue.
+func (bc *BufferedClient) dropEnvelope(e *envelope) {
+	atomic.AddUint64(&bc.shed, 1)
+	atomic.AddInt64(&bc.buffered, -int64(e.size))
+	e.drop(ErrDropped)
+}
+
+// shedBatch settles a batch waiting for a retry that was dropped to stay
+// within the memory budget.
+func (bc *BufferedClient) shedBatch(b *batch) {
+	bc.conf.Logger.WithField("batchId", b.BatchId).WithField("messages", b.len()).Warn("memory budget exceeded, dropping batch")
+	atomic.AddUint64(&bc.shed, uint64(b.len()))
+	if b.spooled != nil {
+		bc.spool.ack(b.spooled)
+	}
+	bc.finish(b, ErrDropped)
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMessageTTL(t *testing.T) {
+	srv := newFakeIngest(t)
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	conf := testBufferedConfig(srv.URL)
+	conf.TypeMaxAges = map[string]time.Duration{"Event": 30 * time.Millisecond}
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := bc.SendAndWait(ctx, newTestMessage(0)); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+	if err := bc.SendAndWait(ctx, newTestMessage(1), WithTTL(time.Nanosecond)); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+	if d := bc.Dropped(); d.Expired != 2 {
+		t.Fatalf("expected 2 expired messages, got %+v", d)
+	}
+}
+
+func TestMemoryBudgetSheds(t *testing.T) {
+	srv := newFakeIngest(t)
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	size, _ := messageSize(newTestMessage(0))
+	conf := testBufferedConfig(srv.URL)
+	conf.MaxMessagesPerBatch = 1
+	conf.MaxBufferedBytes = int64(3 * size)
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var low []*SendFuture
+	for i := 0; i < 3; i++ {
+		low = append(low, bc.SendAsync(ctx, newTestMessage(i), WithPriority(PriorityLow)))
+	}
+	for bc.retries.len() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	high := bc.SendAsync(ctx, newTestMessage(3), WithPriority(PriorityHigh))
+	if !errors.Is(low[0].Err(), ErrDropped) {
+		t.Fatalf("expected the oldest low priority message to be shed, got %v", low[0].Err())
+	}
+	if high.Err() != nil {
+		t.Fatalf("expected the high priority message to be kept, got %v", high.Err())
+	}
+	if d := bc.Dropped(); d.Shed != 1 {
+		t.Fatalf("expected 1 shed message, got %+v", d)
+	}
+
+	cctx, ccancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer ccancel()
+	bc.Close(cctx)
+}
+
+func TestPruneKeepsStatsAndSpool(t *testing.T) {
+	srv := newFakeIngest(t)
+	srv.setHandler(func(w http.ResponseWriter, b *Messages) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	dir := t.TempDir()
+	conf := testBufferedConfig(srv.URL)
+	conf.MaxMessagesPerBatch = 2
+	conf.MaxDurationPerBatch = time.Second
+	conf.SpoolDir = dir
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	expired := bc.SendAsync(ctx, newTestMessage(0), WithTTL(30*time.Millisecond))
+	bc.SendAsync(ctx, newTestMessage(1))
+	if err := expired.Wait(ctx); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+	// wait for an attempt after the prune
+	time.Sleep(50 * time.Millisecond)
+
+	stats := bc.Stats()
+	if stats.BatchSizes.Count != 1 || stats.BatchSizes.Sum != 2 {
+		t.Fatalf("expected the batch to be counted once, got %+v", stats.BatchSizes)
+	}
+
+	cctx, ccancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer ccancel()
+	bc.Close(cctx)
+
+	s, recovered, err := openSpool(testSpoolConfig(dir), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if len(recovered) != 1 || len(recovered[0].Messages.Messages) != 1 {
+		t.Fatalf("expected the expired message to be dropped from the spool, got %+v", recovered)
+	}
+}
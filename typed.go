@@ -0,0 +1,436 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// TypedClient sends values of the struct type T as Event messages through a
+// BufferedClient. Fields tagged `ingest:"name"` become properties of the
+// event, `ingest:"name,omitempty"` leaves out zero values, untagged fields
+// are not sent. Reserved names take the fields of the event:
+//
+//	type Login struct {
+//		At     time.Time `ingest:"#time"`    // time.Time or unix milliseconds, now when zero
+//		UserID int64     `ingest:"#user_id"` // string or integer
+//		IP     string    `ingest:"ip"`
+//		Level  int       `ingest:"level,omitempty"`
+//	}
+//
+// and a string field tagged #event overrides the event name of the client.
+// Values are encoded straight to JSON or msgpack by an encoder built once per
+// type, without going through a map.
+type TypedClient[T any] struct {
+	bc    *BufferedClient
+	enc   *structEncoder
+	event string
+}
+
+// NewTypedClient returns a client sending values of T to bc as events named
+// event, which may be empty when T has an #event field.
+func NewTypedClient[T any](bc *BufferedClient, event string) (*TypedClient[T], error) {
+	enc, err := structEncoderOf(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+	if event == "" && enc.event < 0 {
+		return nil, fmt.Errorf("%w: %s has no #event field and no event name is given", ErrInvalidMessage, enc.typ)
+	}
+	return &TypedClient[T]{bc: bc, enc: enc, event: event}, nil
+}
+
+// Message returns the message of v, for use with Client.Collect. It fails
+// when v has no event name.
+func (c *TypedClient[T]) Message(v T) (*Message, error) {
+	d, err := c.enc.data(reflect.ValueOf(v), c.event)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Type: TypeEvent, Data: d}, nil
+}
+
+// Send queues v, see BufferedClient.Send.
+func (c *TypedClient[T]) Send(ctx context.Context, v T, opts ...SendOption) error {
+	m, err := c.Message(v)
+	if err != nil {
+		return err
+	}
+	return c.bc.Send(ctx, m, opts...)
+}
+
+// TrySend queues v without blocking, see BufferedClient.TrySend.
+func (c *TypedClient[T]) TrySend(v T, opts ...SendOption) error {
+	m, err := c.Message(v)
+	if err != nil {
+		return err
+	}
+	return c.bc.TrySend(m, opts...)
+}
+
+// SendAsync queues v and returns its future, see BufferedClient.SendAsync.
+func (c *TypedClient[T]) SendAsync(ctx context.Context, v T, opts ...SendOption) *SendFuture {
+	m, err := c.Message(v)
+	if err != nil {
+		f := newSendFuture()
+		f.resolve(err)
+		return f
+	}
+	return c.bc.SendAsync(ctx, m, opts...)
+}
+
+// SendAndWait queues v and waits for its delivery, see
+// BufferedClient.SendAndWait.
+func (c *TypedClient[T]) SendAndWait(ctx context.Context, v T, opts ...SendOption) error {
+	return c.SendAsync(ctx, v, opts...).Wait(ctx)
+}
+
+// typedData is the Data of the messages of a TypedClient, it encodes itself
+// as the map an Event message would carry.
+type typedData struct {
+	enc   *structEncoder
+	event string
+	at    int64 // unix milliseconds
+	v     reflect.Value
+}
+
+func (d typedData) MarshalJSON() ([]byte, error) {
+	return d.enc.appendJSON(make([]byte, 0, 128), d)
+}
+
+func (d typedData) EncodeMsgpack(e *msgpack.Encoder) error {
+	return d.enc.encodeMsgpack(e, d)
+}
+
+// field returns the value of the property name, see PartitionByField.
+func (d typedData) field(name string) interface{} {
+	for _, f := range d.enc.fields {
+		if f.name == name {
+			return d.v.Field(f.index).Interface()
+		}
+	}
+	return nil
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+
+	structEncoders sync.Map // reflect.Type to *structEncoder
+)
+
+type structEncoder struct {
+	typ    reflect.Type
+	fields []structField // properties, in declaration order
+	event  int           // index in fields of #event, -1 when none
+	time   int           // index in fields of #time, -1 when none
+}
+
+type structField struct {
+	name      string
+	key       []byte // JSON encoded name followed by a colon
+	index     int
+	kind      reflect.Kind
+	isTime    bool
+	omitEmpty bool
+}
+
+func structEncoderOf(t reflect.Type) (*structEncoder, error) {
+	if enc, ok := structEncoders.Load(t); ok {
+		return enc.(*structEncoder), nil
+	}
+	enc, err := newStructEncoder(t)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := structEncoders.LoadOrStore(t, enc)
+	return actual.(*structEncoder), nil
+}
+
+func newStructEncoder(t reflect.Type) (*structEncoder, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %s is not a struct", ErrInvalidMessage, t)
+	}
+
+	enc := &structEncoder{typ: t, event: -1, time: -1}
+	seen := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("ingest")
+		if !ok || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			return nil, fmt.Errorf("%w: field %s of %s has no property name", ErrInvalidMessage, sf.Name, t)
+		}
+		if sf.PkgPath != "" {
+			return nil, fmt.Errorf("%w: field %s of %s is not exported", ErrInvalidMessage, sf.Name, t)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w: property %q of %s is set twice", ErrInvalidMessage, name, t)
+		}
+		seen[name] = true
+
+		f := structField{
+			name:      name,
+			key:       append(appendJSONString(nil, name), ':'),
+			index:     i,
+			kind:      sf.Type.Kind(),
+			isTime:    sf.Type == timeType,
+			omitEmpty: opts == "omitempty",
+		}
+		switch name {
+		case FieldEvent:
+			if f.kind != reflect.String {
+				return nil, fmt.Errorf("%w: %s field %s of %s is not a string", ErrInvalidMessage, name, sf.Name, t)
+			}
+			enc.event = len(enc.fields)
+		case FieldTime:
+			if !f.isTime && f.kind != reflect.Int64 {
+				return nil, fmt.Errorf("%w: %s field %s of %s is neither a time.Time nor an int64", ErrInvalidMessage, name, sf.Name, t)
+			}
+			enc.time = len(enc.fields)
+		case FieldUserID:
+			switch f.kind {
+			case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			default:
+				return nil, fmt.Errorf("%w: %s field %s of %s is neither a string nor an integer", ErrInvalidMessage, name, sf.Name, t)
+			}
+		default:
+			if strings.HasPrefix(name, "#") {
+				return nil, fmt.Errorf("%w: property name %q of %s is reserved", ErrInvalidMessage, name, t)
+			}
+			switch f.kind {
+			case reflect.Func, reflect.Chan, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+				return nil, fmt.Errorf("%w: field %s of %s of type %s cannot be encoded", ErrInvalidMessage, sf.Name, t, sf.Type)
+			}
+		}
+		enc.fields = append(enc.fields, f)
+	}
+	return enc, nil
+}
+
+// data resolves the event name and time of v, event is the name used when
+// v has none and the time is now when v has none.
+func (enc *structEncoder) data(v reflect.Value, event string) (typedData, error) {
+	if enc.event >= 0 {
+		if name := v.Field(enc.fields[enc.event].index).String(); name != "" {
+			event = name
+		}
+	}
+	if event == "" {
+		return typedData{}, fmt.Errorf("%w: event name is required", ErrInvalidMessage)
+	}
+
+	var at int64
+	if enc.time >= 0 {
+		f := enc.fields[enc.time]
+		if f.isTime {
+			if t := v.Field(f.index).Interface().(time.Time); !t.IsZero() {
+				at = unixMilli(t)
+			}
+		} else {
+			at = v.Field(f.index).Int()
+		}
+	}
+	if at == 0 {
+		at = unixMilli(time.Time{})
+	}
+	return typedData{enc: enc, event: event, at: at, v: v}, nil
+}
+
+// skip tells whether field i is left out of the properties: #event and
+// #time are written first, omitempty fields when zero.
+func (enc *structEncoder) skip(v reflect.Value, i int) bool {
+	f := &enc.fields[i]
+	return i == enc.event || i == enc.time || f.omitEmpty && v.Field(f.index).IsZero()
+}
+
+func (enc *structEncoder) appendJSON(b []byte, d typedData) ([]byte, error) {
+	v := d.v
+	var err error
+	b = append(b, `{"#event":`...)
+	b = appendJSONString(b, d.event)
+	b = append(b, `,"#time":`...)
+	b = strconv.AppendInt(b, d.at, 10)
+	for i := range enc.fields {
+		if enc.skip(v, i) {
+			continue
+		}
+		f := &enc.fields[i]
+		b = append(b, ',')
+		b = append(b, f.key...)
+		if b, err = appendJSONValue(b, f, v.Field(f.index)); err != nil {
+			return nil, err
+		}
+	}
+	return append(b, '}'), nil
+}
+
+func appendJSONValue(b []byte, f *structField, fv reflect.Value) ([]byte, error) {
+	if f.isTime {
+		b = append(b, '"')
+		b = fv.Interface().(time.Time).AppendFormat(b, time.RFC3339Nano)
+		return append(b, '"'), nil
+	}
+	switch f.kind {
+	case reflect.String:
+		return appendJSONString(b, fv.String()), nil
+	case reflect.Bool:
+		return strconv.AppendBool(b, fv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(b, fv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.AppendUint(b, fv.Uint(), 10), nil
+	case reflect.Float32:
+		return appendJSONFloat(b, fv.Float(), 32)
+	case reflect.Float64:
+		return appendJSONFloat(b, fv.Float(), 64)
+	}
+	data, err := fastjson.Marshal(fv.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return append(b, data...), nil
+}
+
+// appendJSONFloat formats f like encoding/json.
+func appendJSONFloat(b []byte, f float64, bits int) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, fmt.Errorf("unsupported value: %v", f)
+	}
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21)) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b, nil
+}
+
+const hex = "0123456789abcdef"
+
+// appendJSONString quotes s like encoding/json, HTML characters included.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
+
+func (enc *structEncoder) encodeMsgpack(e *msgpack.Encoder, d typedData) error {
+	v := d.v
+
+	n := 2
+	for i := range enc.fields {
+		if !enc.skip(v, i) {
+			n++
+		}
+	}
+	if err := e.EncodeMapLen(n); err != nil {
+		return err
+	}
+	if err := e.EncodeString(FieldEvent); err != nil {
+		return err
+	}
+	if err := e.EncodeString(d.event); err != nil {
+		return err
+	}
+	if err := e.EncodeString(FieldTime); err != nil {
+		return err
+	}
+	if err := e.EncodeInt64(d.at); err != nil {
+		return err
+	}
+	for i := range enc.fields {
+		if enc.skip(v, i) {
+			continue
+		}
+		f := &enc.fields[i]
+		if err := e.EncodeString(f.name); err != nil {
+			return err
+		}
+		if err := encodeMsgpackValue(e, f, v.Field(f.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeMsgpackValue(e *msgpack.Encoder, f *structField, fv reflect.Value) error {
+	if f.isTime {
+		return e.EncodeTime(fv.Interface().(time.Time))
+	}
+	switch f.kind {
+	case reflect.String:
+		return e.EncodeString(fv.String())
+	case reflect.Bool:
+		return e.EncodeBool(fv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return e.EncodeInt64(fv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return e.EncodeUint64(fv.Uint())
+	case reflect.Float32:
+		return e.EncodeFloat32(float32(fv.Float()))
+	case reflect.Float64:
+		return e.EncodeFloat64(fv.Float())
+	}
+	return e.Encode(fv.Interface())
+}
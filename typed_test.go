@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+type testLogin struct {
+	At     time.Time         `ingest:"#time"`
+	UserID int64             `ingest:"#user_id"`
+	IP     string            `ingest:"ip"`
+	Level  int               `ingest:"level,omitempty"`
+	Score  float64           `ingest:"score"`
+	Ratio  float32           `ingest:"ratio"`
+	VIP    bool              `ingest:"vip"`
+	Items  []string          `ingest:"items"`
+	Extra  map[string]string `ingest:"extra,omitempty"`
+	Note   string
+}
+
+func TestTypedEncoding(t *testing.T) {
+	at := time.UnixMilli(1728904200000)
+	v := testLogin{At: at, UserID: 1 << 60, IP: "<127.0.0.1> \"\u2028\n\xff&", Score: 1e-7, Ratio: 0.1, VIP: true, Items: []string{"sword"}, Note: "not sent"}
+	want := map[string]interface{}{
+		"#event": "login", "#time": at.UnixMilli(), "#user_id": v.UserID,
+		"ip": v.IP, "score": v.Score, "ratio": v.Ratio, "vip": true, "items": v.Items,
+	}
+
+	c, err := NewTypedClient[testLogin](nil, "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, enc := range []string{"json", "msgpack"} {
+		m, err := c.Message(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := encoding(enc, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := encoding(enc, &Message{Type: TypeEvent, Data: want})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// jsoniter formats some floats unlike encoding/json, compare values
+		decode := func(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+		if enc == "json" {
+			decode = json.Unmarshal
+		}
+		var gotMsg, expectedMsg map[string]interface{}
+		if err := decode(got, &gotMsg); err != nil {
+			t.Fatalf("%s: %v", enc, err)
+		}
+		if err := decode(expected, &expectedMsg); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(gotMsg, expectedMsg) {
+			t.Fatalf("%s: expected %v, got %v", enc, expectedMsg, gotMsg)
+		}
+	}
+
+	// strings and floats are formatted like encoding/json
+	m, _ := c.Message(v)
+	data, err := json.Marshal(m.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, _ := json.Marshal(v.IP)
+	for _, s := range []string{`"ip":` + string(ip), `"score":1e-7`, `"ratio":0.1`} {
+		if !strings.Contains(string(data), s) {
+			t.Fatalf("expected %s in %s", s, data)
+		}
+	}
+}
+
+func TestTypedClient(t *testing.T) {
+	type logout struct {
+		Event  string `ingest:"#event"`
+		UserID string `ingest:"#user_id"`
+	}
+
+	srv := newFakeIngest(t)
+	conf := testBufferedConfig(srv.URL)
+	conf.PartitionKey = PartitionByField(FieldUserID)
+	bc, err := NewBufferedClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close(context.Background())
+
+	c, err := NewTypedClient[logout](bc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendAndWait(context.Background(), logout{Event: "logout", UserID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	msgs := srv.batches[0].Messages
+	srv.mu.Unlock()
+	data := msgs[0].Data.(map[string]interface{})
+	if msgs[0].Type != TypeEvent || data[FieldEvent] != "logout" || data[FieldUserID] != "u1" || data[FieldTime] == nil {
+		t.Fatalf("unexpected message %+v", msgs[0])
+	}
+	if err := c.SendAndWait(context.Background(), logout{UserID: "u1"}); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expected ErrInvalidMessage without event name, got %v", err)
+	}
+
+	type bad struct {
+		Time string `ingest:"#time"`
+	}
+	if _, err := NewTypedClient[bad](bc, "bad"); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expected ErrInvalidMessage, got %v", err)
+	}
+	if _, err := NewTypedClient[logout](bc, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTypedClient[testLogin](bc, ""); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expected ErrInvalidMessage without event name, got %v", err)
+	}
+}
+
+func BenchmarkTypedEncoding(b *testing.B) {
+	v := testLogin{At: time.Now(), UserID: 42, IP: "127.0.0.1", Level: 3, Score: 99.5, Items: []string{"sword"}}
+	c, _ := NewTypedClient[testLogin](nil, "login")
+
+	b.Run("map", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m := &Message{Type: TypeEvent, Data: map[string]interface{}{
+				"#event": "login", "#time": v.At.UnixMilli(), "#user_id": v.UserID,
+				"ip": v.IP, "level": v.Level, "score": v.Score, "ratio": v.Ratio, "vip": v.VIP, "items": v.Items,
+			}}
+			if _, err := encoding("json", m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("typed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m, _ := c.Message(v)
+			if _, err := encoding("json", m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+var _ msgpack.CustomEncoder = typedData{}